@@ -0,0 +1,99 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+// accessLog logs each request served, if access logging is turned on.
+var accessLog func(requestRecord)
+
+// newAccessLog returns a function that logs requests to w in format: clf
+// for the Common Log Format followed by the extra fields, json for a JSON
+// object per line, or empty for no logging.
+func newAccessLog(format string, w io.Writer) (func(requestRecord), error) {
+	// log.Logger serializes writes, so lines are never interleaved.
+	l := log.New(w, "", 0)
+	switch format {
+	case "":
+		return nil, nil
+	case "clf":
+		return func(rr requestRecord) {
+			r := rr.Request
+			l.Printf("%s - - [%s] %s %d %d path=%s subpath=%s go_get=%t duration=%s request_id=%s",
+				clientIP(r),
+				rr.Start.Format("02/Jan/2006:15:04:05 -0700"),
+				strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto),
+				rr.Status,
+				rr.Bytes,
+				strconv.Quote(rr.Path),
+				strconv.Quote(rr.Subpath),
+				rr.GoGet,
+				rr.Duration,
+				requestID(r.Context()))
+		}, nil
+	case "json":
+		return func(rr requestRecord) {
+			r := rr.Request
+			data, err := json.Marshal(struct {
+				Time      time.Time `json:"time"`
+				RemoteIP  string    `json:"remote_ip"`
+				Method    string    `json:"method"`
+				Host      string    `json:"host"`
+				URI       string    `json:"uri"`
+				Proto     string    `json:"proto"`
+				Status    int       `json:"status"`
+				Bytes     int64     `json:"bytes"`
+				Path      string    `json:"path,omitempty"`
+				Subpath   string    `json:"subpath,omitempty"`
+				GoGet     bool      `json:"go_get"`
+				GoCommand bool      `json:"go_command"`
+				Referer   string    `json:"referer,omitempty"`
+				UserAgent string    `json:"user_agent,omitempty"`
+				Duration  float64   `json:"duration_seconds"`
+				RequestID string    `json:"request_id"`
+			}{
+				Time:      rr.Start,
+				RemoteIP:  clientIP(r),
+				Method:    r.Method,
+				Host:      r.Host,
+				URI:       r.RequestURI,
+				Proto:     r.Proto,
+				Status:    rr.Status,
+				Bytes:     rr.Bytes,
+				Path:      rr.Path,
+				Subpath:   rr.Subpath,
+				GoGet:     rr.GoGet,
+				GoCommand: rr.GoCommand,
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+				Duration:  rr.Duration.Seconds(),
+				RequestID: requestID(r.Context()),
+			})
+			if err != nil {
+				return
+			}
+			l.Print(string(data))
+		}, nil
+	default:
+		return nil, fmt.Errorf("access_log must be clf or json")
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager returns a manager that obtains certificates from Let's
+// Encrypt for the configured hosts, keeping them in cacheDir.
+func newACMEManager(cacheDir, email string) (*autocert.Manager, error) {
+	var names []string
+	for _, h := range hosts {
+		if h.host != "" {
+			names = append(names, h.host)
+		}
+	}
+	names = append(names, allowedHosts...)
+	if len(names) == 0 {
+		return nil, errors.New("ACME requires host, hosts, or allowed_hosts to be set")
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(names...),
+		Email:      email,
+	}, nil
+}
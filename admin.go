@@ -0,0 +1,75 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine && !lambda && !cloudfunctions && !caddy
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// newAdminServer returns the server for the admin listener, which serves
+// the management endpoints in place of the public listener. If a client
+// CA is configured, clients must present a certificate it signed.
+func newAdminServer() (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthPath, serveHealth)
+	mux.HandleFunc(readyPath, serveHealth)
+	mux.HandleFunc(versionPath, serveVersion)
+	if debugHandler != nil {
+		mux.Handle(debugPrefix, debugHandler)
+	}
+	if adminHandler != nil {
+		mux.Handle(adminPrefix, adminHandler)
+	}
+	if metricsHandler != nil {
+		mux.Handle(metricsPath, metricsHandler)
+	}
+	if editHandler != nil {
+		mux.Handle(apiPathsPrefix+"/", editHandler)
+	}
+	srv := newServer(mux)
+	if (adminTLSCert == "") != (adminTLSKey == "") {
+		return nil, fmt.Errorf("both an admin TLS certificate and key are required")
+	}
+	if adminTLSCert == "" {
+		if adminClientCA != "" {
+			return nil, fmt.Errorf("admin_client_ca requires an admin TLS certificate")
+		}
+		return srv, nil
+	}
+	certs, err := newCertReloader(adminTLSCert, adminTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	if adminClientCA != "" {
+		pem, err := os.ReadFile(adminClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", adminClientCA)
+		}
+		srv.TLSConfig.ClientCAs = pool
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return srv, nil
+}
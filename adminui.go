@@ -0,0 +1,269 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type adminUIPath struct {
+	Path     string
+	Import   string
+	GoImport string
+	GoSource string
+	// Entry is the path's entry in pathsFile, as YAML, or empty if the
+	// path is set in the config file or discovered.
+	Entry string
+}
+
+type adminUIHost struct {
+	Name  string
+	Host  string
+	Paths []adminUIPath
+}
+
+// newAdminMux returns the handler for the endpoints under adminPrefix,
+// which requireAdmin guards.
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminPrefix, serveAdminUI)
+	mux.HandleFunc(adminPrefix+"admin.css", serveAdminCSS)
+	mux.HandleFunc(adminPrefix+"maintenance", serveMaintenanceToggle)
+	mux.HandleFunc(adminPrefix+"status", serveStatus)
+	return mux
+}
+
+// serveAdminUI serves /-/admin/, a page for browsing and editing the
+// paths, previewing their meta tags, and reloading the config. Changes
+// are posted back to it as forms and made as through the paths API.
+func serveAdminUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != adminPrefix {
+		notFound(w, r)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+	case "POST":
+		postAdminUI(w, r)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	entries, err := rawPathEntries()
+	if err != nil {
+		logRenderError(r, "admin", err)
+	}
+	var page struct {
+		Hosts     []adminUIHost
+		Done      string
+		Error     string
+		CanReload bool
+	}
+	page.Done = r.URL.Query().Get("done")
+	page.Error = r.URL.Query().Get("error")
+	page.CanReload = reloadConfig != nil
+	for i, h := range hosts {
+		name := h.host
+		if name == "" {
+			name = requestHost(r)
+		}
+		uh := adminUIHost{Name: name, Host: h.host}
+		if i == 0 && h.host == "" {
+			uh.Name += " (default)"
+		}
+		for path, p := range h.pathMap() {
+			imp := name + pathPrefix + path
+			up := adminUIPath{
+				Path:     path,
+				Import:   imp,
+				GoImport: imp + " " + p.VCS + " " + p.Repo,
+				Entry:    entries[strings.ToLower(h.host)][path],
+			}
+			if p.Display != "" {
+				up.GoSource = imp + " " + p.Display
+			}
+			uh.Paths = append(uh.Paths, up)
+		}
+		sort.Slice(uh.Paths, func(i, j int) bool {
+			return uh.Paths[i].Path < uh.Paths[j].Path
+		})
+		page.Hosts = append(page.Hosts, uh)
+	}
+	var buf bytes.Buffer
+	if err := adminUITmpl.Execute(&buf, page); err != nil {
+		logRenderError(r, "admin", err)
+		httpError(w, r, "cannot render the page", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// serveAdminCSS serves the admin page's styles. They are kept out of the
+// page so that the default Content-Security-Policy, which allows only
+// same-origin stylesheets, doesn't block them.
+func serveAdminCSS(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, "text/css; charset=utf-8", []byte(adminCSS))
+}
+
+// postAdminUI makes the change asked for by a form on the admin page,
+// then sends the browser back to it with the outcome.
+func postAdminUI(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxEditBodyBytes)
+	if err := r.ParseForm(); err != nil {
+		httpError(w, r, "invalid form", http.StatusBadRequest)
+		return
+	}
+	host, path := r.PostForm.Get("host"), r.PostForm.Get("path")
+	var (
+		done string
+		err  error
+	)
+	switch action := r.PostForm.Get("action"); action {
+	case "reload":
+		if reloadConfig == nil {
+			err = errNoReload
+			break
+		}
+		err = reloadConfig()
+		e := newAuditEvent(r, "reload")
+		if err != nil {
+			e.Error = err.Error()
+		}
+		recordAudit(e)
+		done = "Reloaded the config."
+	case "add", "save", "delete":
+		if !validEditPath(path) {
+			err = fmt.Errorf("invalid path %q", path)
+			break
+		}
+		method := map[string]string{"add": "POST", "save": "PUT", "delete": "DELETE"}[action]
+		var entry pathConfig
+		if method != "DELETE" {
+			entry, err = parsePathEntry([]byte(r.PostForm.Get("entry")))
+			if err != nil {
+				break
+			}
+		}
+		e := newAuditEvent(r, editActions[method])
+		e.Host, e.Path = host, path
+		err = editPaths(e, method, entry)
+		done = map[string]string{"add": "Added ", "save": "Saved ", "delete": "Removed "}[action] + path + "."
+	default:
+		httpError(w, r, "unknown action", http.StatusBadRequest)
+		return
+	}
+	q := url.Values{}
+	if err != nil {
+		q.Set("error", err.Error())
+	} else {
+		q.Set("done", done)
+	}
+	http.Redirect(w, r, adminPrefix+"?"+q.Encode(), http.StatusSeeOther)
+}
+
+// rawPathEntries returns the entry of each path kept in pathsFile as
+// YAML, by lowercased host name and path. The paths at the top level are
+// under the name of the top-level host, if any.
+func rawPathEntries() (map[string]map[string]string, error) {
+	m, _, err := readManagedPaths(pathsFile)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]map[string]string)
+	add := func(host string, paths map[string]pathConfig) error {
+		e := make(map[string]string)
+		for path, p := range paths {
+			data, err := yaml.Marshal(p)
+			if err != nil {
+				return err
+			}
+			e[path] = string(data)
+		}
+		entries[strings.ToLower(host)] = e
+		return nil
+	}
+	if err := add(hosts[0].host, m.Paths); err != nil {
+		return nil, err
+	}
+	for host, paths := range m.Hosts {
+		if err := add(host, paths); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+var adminUITmpl = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Admin</title>
+<link rel="stylesheet" href="admin.css">
+</head>
+<body>
+<h1>Admin</h1>
+<p><a href="status">Status</a></p>
+{{with .Done}}<p class="done">{{.}}</p>
+{{end}}{{with .Error}}<p class="error">{{.}}</p>
+{{end}}{{if .CanReload}}<form method="post"><input type="hidden" name="action" value="reload"><button>Reload the config</button></form>
+{{end}}{{range $h := .Hosts}}<h2>{{.Name}}</h2>
+<table>
+<tr><th>Path</th><th>Meta tags</th><th></th></tr>
+{{range .Paths}}<tr>
+<td><code>{{.Path}}</code></td>
+<td><pre>&lt;meta name="go-import" content="{{.GoImport}}"&gt;{{with .GoSource}}
+&lt;meta name="go-source" content="{{.}}"&gt;{{end}}</pre></td>
+<td>{{if .Entry}}<details><summary>Edit</summary>
+<form method="post">
+<input type="hidden" name="host" value="{{$h.Host}}">
+<input type="hidden" name="path" value="{{.Path}}">
+<textarea name="entry" rows="6">{{.Entry}}</textarea>
+<button name="action" value="save">Save</button>
+<button name="action" value="delete">Remove</button>
+</form>
+</details>{{end}}</td>
+</tr>
+{{end}}</table>
+<details><summary>Add a path</summary>
+<form method="post">
+<input type="hidden" name="host" value="{{.Host}}">
+<p><label>Path <input name="path" placeholder="/mymodule"></label></p>
+<textarea name="entry" rows="6" placeholder="repo: https://github.com/you/mymodule"></textarea>
+<button name="action" value="add">Add</button>
+</form>
+</details>
+{{end}}</body>
+</html>
+`))
+
+const adminCSS = `body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.4em; text-align: left; vertical-align: top; }
+pre { margin: 0; white-space: pre-wrap; word-break: break-all; }
+textarea { width: 100%; font-family: monospace; }
+.done { color: #060; }
+.error { color: #a00; }
+`
@@ -0,0 +1,55 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAdminUIStylesAllowedByCSP(t *testing.T) {
+	setupHost(t, &hostConfig{Paths: map[string]pathConfig{
+		"/portmidi": {Repo: "https://github.com/rakyll/portmidi"},
+	}})
+	oldAdmin, oldCSP := adminHandler, csp
+	t.Cleanup(func() {
+		adminHandler, csp = oldAdmin, oldCSP
+	})
+	adminHandler, csp = newAdminMux(), defaultCSP
+
+	page := sendRequest(handle, "GET", adminPrefix, nil, "")
+	if page.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d; want %d", adminPrefix, page.Code, http.StatusOK)
+	}
+	policy := page.Header().Get("Content-Security-Policy")
+	if policy != defaultCSP {
+		t.Errorf("GET %s Content-Security-Policy = %q; want %q", adminPrefix, policy, defaultCSP)
+	}
+	// The default policy allows only same-origin stylesheets, so the
+	// page's styles must come from one.
+	body := page.Body.String()
+	if strings.Contains(body, "<style") || strings.Contains(body, " style=") {
+		t.Errorf("page has inline styles, which %q blocks:\n%s", policy, body)
+	}
+	if !strings.Contains(body, `<link rel="stylesheet" href="admin.css">`) {
+		t.Errorf("page doesn't link to admin.css:\n%s", body)
+	}
+
+	css := sendRequest(handle, "GET", adminPrefix+"admin.css", nil, "")
+	if css.Code != http.StatusOK || !strings.HasPrefix(css.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("GET %sadmin.css = %d, Content-Type %q; want %d, text/css", adminPrefix, css.Code, css.Header().Get("Content-Type"), http.StatusOK)
+	}
+}
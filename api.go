@@ -0,0 +1,101 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// apiPathsPrefix is the path of the read-only paths API.
+const apiPathsPrefix = "/api/v1/paths"
+
+// apiPath is the resolved configuration of a path, as served by the
+// paths API.
+type apiPath struct {
+	Path        string `json:"path"`
+	Import      string `json:"import"`
+	VCS         string `json:"vcs"`
+	Repo        string `json:"repo"`
+	Display     string `json:"display,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	Redirect    string `json:"redirect"`
+	DocsURL     string `json:"docs_url"`
+	Description string `json:"description,omitempty"`
+	Proxy       bool   `json:"proxy"`
+}
+
+func (h *vanityHost) newAPIPath(host, path string, p pathConfig) apiPath {
+	return apiPath{
+		Path:        path,
+		Import:      host + path,
+		VCS:         p.VCS,
+		Repo:        p.Repo,
+		Display:     p.Display,
+		Branch:      p.Branch,
+		Redirect:    p.Redirect,
+		DocsURL:     h.docsURLFor(host + path),
+		Description: p.Description,
+		Proxy:       p.Proxy,
+	}
+}
+
+// serveAPI serves GET /api/v1/paths, listing every path, and
+// GET /api/v1/paths/<path>, describing a single one.
+func (h *vanityHost) serveAPI(w http.ResponseWriter, r *http.Request, host string) {
+	rest := strings.TrimPrefix(r.URL.Path, apiPathsPrefix)
+	if rest == "" || rest == "/" {
+		all := h.pathMap()
+		paths := make([]apiPath, 0, len(all))
+		for path, p := range all {
+			paths = append(paths, h.newAPIPath(host, path, p))
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			return paths[i].Path < paths[j].Path
+		})
+		writeJSON(w, r, http.StatusOK, struct {
+			Paths []apiPath `json:"paths"`
+		}{paths})
+		return
+	}
+	p, ok := h.pathMap()[rest]
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, "no such path "+rest)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, h.newAPIPath(host, rest, p))
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		logRenderError(r, "json", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "cannot render the response")
+		return
+	}
+	setCORSHeaders(w, r)
+	writeResponse(w, r, code, "application/json", append(data, '\n'))
+}
+
+func writeJSONError(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	data, _ := json.Marshal(struct {
+		Error     string `json:"error"`
+		RequestID string `json:"request_id,omitempty"`
+	}{msg, requestID(r.Context())})
+	setCORSHeaders(w, r)
+	writeResponse(w, r, code, "application/json", append(data, '\n'))
+}
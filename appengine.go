@@ -0,0 +1,50 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build appengine
+
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"google.golang.org/appengine/v2"
+)
+
+// init loads vanity.yaml from the app directory, which App Engine runs the
+// app in.
+func init() {
+	loadConfig("./vanity.yaml")
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+	logConfig()
+	http.HandleFunc("/", handle)
+	isReady.Store(true)
+}
+
+// main serves the app on the port in $PORT, as App Engine asks.
+func main() {
+	appengine.Main()
+}
+
+// defaultHost returns the host that a trusted proxy forwarded r for, or
+// else the host name of the App Engine app serving r.
+func defaultHost(r *http.Request) string {
+	if host, _ := forwarded(r); host != "" {
+		return host
+	}
+	return appengine.DefaultVersionHostname(appengine.NewContext(r))
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// auditLogFile is the file that changes to the config are appended
+	// to, and auditWebhook the URL they are posted to, if any.
+	auditLogFile string
+	auditWebhook string
+)
+
+// auditClient posts to the audit webhook.
+var auditClient = &http.Client{Timeout: 10 * time.Second}
+
+// auditEvent records a change made to the config or the server, in the
+// audit log.
+type auditEvent struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// User is who made the change, as found by requireAdmin, and Client
+	// the address it came from.
+	User      string `json:"user,omitempty"`
+	Client    string `json:"client,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// Signal is the signal that started a reload.
+	Signal string `json:"signal,omitempty"`
+	Host   string `json:"host,omitempty"`
+	Path   string `json:"path,omitempty"`
+	// Diff lists the lines removed from the config file, prefixed with
+	// "-", and those added, prefixed with "+".
+	Diff  string `json:"diff,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// newAuditEvent returns the event for action asked for by r.
+func newAuditEvent(r *http.Request, action string) auditEvent {
+	return auditEvent{
+		Action:    action,
+		User:      adminUser(r.Context()),
+		Client:    clientIP(r),
+		RequestID: requestID(r.Context()),
+	}
+}
+
+// recordAudit appends e to the audit log and posts it to the audit
+// webhook. Failures are logged, but don't undo the change.
+func recordAudit(e auditEvent) {
+	if auditLogFile == "" && auditWebhook == "" {
+		return
+	}
+	e.Time = time.Now().UTC()
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("cannot record audit event", "action", e.Action, "err", err)
+		return
+	}
+	data = append(data, '\n')
+	if auditLogFile != "" {
+		if err := appendFile(auditLogFile, data); err != nil {
+			slog.Error("cannot write the audit log", "file", auditLogFile, "err", err)
+		}
+	}
+	if auditWebhook != "" {
+		resp, err := auditClient.Post(auditWebhook, "application/json", bytes.NewReader(data))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("%s", resp.Status)
+			}
+		}
+		if err != nil {
+			slog.Error("cannot post to the audit webhook", "action", e.Action, "err", err)
+		}
+	}
+}
+
+// appendFile appends data to file in a single write, creating the file
+// if need be. Writes from the processes before and after an upgrade
+// don't interleave.
+func appendFile(file string, data []byte) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// diffLines returns the lines removed from a to get b, prefixed with "-",
+// and those added, prefixed with "+", in order.
+func diffLines(a, b string) string {
+	x, y := strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var sb strings.Builder
+	line := func(prefix, s string) {
+		if s == "" {
+			return
+		}
+		sb.WriteString(prefix + strings.TrimSuffix(s, "\n") + "\n")
+	}
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			line("-", x[i])
+			i++
+		default:
+			line("+", y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		line("-", x[i])
+	}
+	for ; j < len(y); j++ {
+		line("+", y[j])
+	}
+	return sb.String()
+}
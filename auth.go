@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Environment variables holding the credentials that guard the
+// management endpoints: a bearer token, or a user name and password for
+// basic auth, or both.
+const (
+	adminTokenEnv    = "GOVANITYURLS_ADMIN_TOKEN"
+	adminUserEnv     = "GOVANITYURLS_ADMIN_USER"
+	adminPasswordEnv = "GOVANITYURLS_ADMIN_PASSWORD"
+
+	// debugTokenEnv is the old name of adminTokenEnv, still read if
+	// adminTokenEnv isn't set.
+	debugTokenEnv = "GOVANITYURLS_DEBUG_TOKEN"
+)
+
+// adminAuth is the credentials that guard the management endpoints.
+type adminAuth struct {
+	token          string
+	user, password string
+	// login, if not nil, lets browsers log in instead.
+	login adminLogin
+}
+
+// adminLogin lets browsers log in to the management endpoints through a
+// login session, like one started with OpenID Connect.
+type adminLogin interface {
+	// ServeHTTP serves the endpoints under loginPrefix.
+	http.Handler
+	// user returns who is logged in with the session of r, if anyone.
+	user(r *http.Request) string
+	// start sends the browser off to log in, returning to r's URL.
+	start(w http.ResponseWriter, r *http.Request)
+}
+
+// loginPrefix is the path under which the login endpoints are served.
+const loginPrefix = adminPrefix + "login/"
+
+// adminAuthFromEnv reads the credentials from the environment.
+func adminAuthFromEnv() adminAuth {
+	a := adminAuth{
+		token:    os.Getenv(adminTokenEnv),
+		user:     os.Getenv(adminUserEnv),
+		password: os.Getenv(adminPasswordEnv),
+	}
+	if a.token == "" {
+		a.token = os.Getenv(debugTokenEnv)
+	}
+	return a
+}
+
+// enabled reports whether any credentials are set.
+func (a adminAuth) enabled() bool {
+	return a.token != "" || a.user != "" && a.password != "" || a.login != nil
+}
+
+// identify reports whether r carries the credentials, and if so, who
+// sent it: the basic auth user name, the user logged in, or "token" for
+// the bearer token.
+func (a adminAuth) identify(r *http.Request) (user string, ok bool) {
+	if a.token != "" {
+		if got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(got, a.token) {
+			return "token", true
+		}
+	}
+	if a.user != "" && a.password != "" {
+		if user, password, ok := r.BasicAuth(); ok {
+			// Check both, so as not to reveal which was wrong.
+			userOK := secureEqual(user, a.user)
+			passwordOK := secureEqual(password, a.password)
+			return user, userOK && passwordOK
+		}
+	}
+	if a.login != nil {
+		if user := a.login.user(r); user != "" {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+// secureEqual reports whether a and b are equal in time that depends on
+// neither. Hashing first hides their lengths too.
+func secureEqual(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// requireAdmin returns a handler that passes requests on to h only if
+// they carry the credentials in a.
+func requireAdmin(a adminAuth, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.login != nil && strings.HasPrefix(r.URL.Path, loginPrefix) {
+			a.login.ServeHTTP(w, r)
+			return
+		}
+		user, ok := a.identify(r)
+		if !ok {
+			if a.login != nil && r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				a.login.start(w, r)
+				return
+			}
+			if a.token != "" {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="govanityurls"`)
+			}
+			if a.user != "" && a.password != "" {
+				w.Header().Add("WWW-Authenticate", `Basic realm="govanityurls", charset="UTF-8"`)
+			}
+			httpError(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// Browsers resend basic auth credentials on their own, so keep
+		// other sites from making changes with them.
+		if r.Method != "GET" && r.Method != "HEAD" && crossSite(r) {
+			httpError(w, r, "cross-site request refused", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminUserKey{}, user)))
+	})
+}
+
+type adminUserKey struct{}
+
+// adminUser returns who sent a request to the management endpoints, as
+// found by requireAdmin, or empty if no credentials were required.
+func adminUser(ctx context.Context) string {
+	user, _ := ctx.Value(adminUserKey{}).(string)
+	return user
+}
+
+// crossSite reports whether a browser sent r on behalf of a page from
+// another origin.
+func crossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || !strings.EqualFold(u.Host, r.Host)
+	}
+	return false
+}
+
+// oidcConfig configures logging in to the management endpoints with
+// OpenID Connect.
+type oidcConfig struct {
+	Issuer       string `yaml:"issuer,omitempty"`
+	ClientID     string `yaml:"client_id,omitempty"`
+	ClientSecret string `yaml:"client_secret,omitempty"`
+	// RedirectURL is the URL of the callback endpoint, under loginPrefix,
+	// as registered with the provider.
+	RedirectURL string `yaml:"redirect_url,omitempty"`
+	// Scopes are asked for in addition to openid, email, and profile.
+	Scopes []string `yaml:"scopes,omitempty"`
+	// AllowedGroups lists the groups whose members may log in, as found
+	// in the ID token claim named by GroupsClaim ("groups" by default).
+	AllowedGroups []string `yaml:"allowed_groups,omitempty"`
+	GroupsClaim   string   `yaml:"groups_claim,omitempty"`
+}
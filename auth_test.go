@@ -0,0 +1,104 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	auth := adminAuth{token: "t0ken", user: "admin", password: "s3cret"}
+	var gotUser string
+	h := requireAdmin(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = adminUser(r.Context())
+	}))
+	basic := func(user, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		want   int
+		user   string
+	}{
+		{"no credentials", "GET", nil, http.StatusUnauthorized, ""},
+		{"token", "GET", http.Header{"Authorization": {"Bearer t0ken"}}, http.StatusOK, "token"},
+		{"wrong token", "GET", http.Header{"Authorization": {"Bearer t0ke"}}, http.StatusUnauthorized, ""},
+		{"empty token", "GET", http.Header{"Authorization": {"Bearer "}}, http.StatusUnauthorized, ""},
+		{"basic auth", "GET", http.Header{"Authorization": {basic("admin", "s3cret")}}, http.StatusOK, "admin"},
+		{"wrong password", "GET", http.Header{"Authorization": {basic("admin", "secret")}}, http.StatusUnauthorized, ""},
+		{"wrong user", "GET", http.Header{"Authorization": {basic("root", "s3cret")}}, http.StatusUnauthorized, ""},
+		{
+			"cross-site POST",
+			"POST",
+			http.Header{"Authorization": {basic("admin", "s3cret")}, "Sec-Fetch-Site": {"cross-site"}},
+			http.StatusForbidden,
+			"",
+		},
+		{
+			"POST from another origin",
+			"POST",
+			http.Header{"Authorization": {basic("admin", "s3cret")}, "Origin": {"https://evil.example.com"}},
+			http.StatusForbidden,
+			"",
+		},
+		{
+			"same-origin POST",
+			"POST",
+			http.Header{"Authorization": {basic("admin", "s3cret")}, "Sec-Fetch-Site": {"same-origin"}, "Origin": {"http://go.example.com"}},
+			http.StatusOK,
+			"admin",
+		},
+		{
+			"cross-site GET",
+			"GET",
+			http.Header{"Authorization": {basic("admin", "s3cret")}, "Sec-Fetch-Site": {"cross-site"}},
+			http.StatusOK,
+			"admin",
+		},
+	}
+	for _, test := range tests {
+		gotUser = ""
+		w := sendRequest(h.ServeHTTP, test.method, adminPrefix, test.header, "")
+		if w.Code != test.want || gotUser != test.user {
+			t.Errorf("%s: %s %s = %d, user %q; want %d, user %q", test.name, test.method, adminPrefix, w.Code, gotUser, test.want, test.user)
+		}
+		if w.Code == http.StatusUnauthorized && len(w.Header()["Www-Authenticate"]) != 2 {
+			t.Errorf("%s: WWW-Authenticate = %q; want Bearer and Basic challenges", test.name, w.Header()["Www-Authenticate"])
+		}
+	}
+}
+
+func TestSecureEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"s3cret", "s3cret", true},
+		{"", "", true},
+		{"s3cret", "s3cre", false},
+		{"s3cret", "s3cret ", false},
+		{"s3cret", "S3cret", false},
+		{"s3cret", "", false},
+	}
+	for _, test := range tests {
+		if got := secureEqual(test.a, test.b); got != test.want {
+			t.Errorf("secureEqual(%q, %q) = %t; want %t", test.a, test.b, got, test.want)
+		}
+	}
+}
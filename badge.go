@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// badgePrefix is the path under which badges are served, as
+// /badge/<path>.svg.
+const badgePrefix = "/badge/"
+
+const badgeLabel = "go get"
+
+// badgeTextWidth estimates the width in pixels of s rendered in the badge
+// font. It is only approximate, but badges tolerate a little slack.
+func badgeTextWidth(s string) int {
+	return utf8.RuneCountInString(s)*7 + 10
+}
+
+// serveBadge serves a shields.io-style SVG badge showing the go get
+// command for a path.
+func (h *vanityHost) serveBadge(w http.ResponseWriter, r *http.Request, host string) {
+	path := "/" + strings.TrimPrefix(r.URL.Path, badgePrefix)
+	if !strings.HasSuffix(path, ".svg") {
+		h.serveNotFound(w, r, host)
+		return
+	}
+	path = strings.TrimSuffix(path, ".svg")
+	if _, ok := h.pathMap()[path]; !ok {
+		h.serveNotFound(w, r, host)
+		return
+	}
+	importPath := host + path
+	labelWidth, valueWidth := badgeTextWidth(badgeLabel), badgeTextWidth(importPath)
+	var buf bytes.Buffer
+	err := badgeTmpl.Execute(&buf, struct {
+		Label, Value           string
+		LabelWidth, ValueWidth int
+		Width, LabelX, ValueX  int
+	}{
+		Label:      badgeLabel,
+		Value:      importPath,
+		LabelWidth: labelWidth,
+		ValueWidth: valueWidth,
+		Width:      labelWidth + valueWidth,
+		LabelX:     labelWidth / 2,
+		ValueX:     labelWidth + valueWidth/2,
+	})
+	if err != nil {
+		logRenderError(r, "badge", err)
+		httpError(w, r, "cannot render the badge", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, "image/svg+xml", buf.Bytes())
+}
+
+var badgeTmpl, _ = template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Value}}">
+<title>{{.Label}} {{.Value}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="#555"/>
+<rect x="{{.LabelWidth}}" width="{{.ValueWidth}}" height="20" fill="#00add8"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="14">{{.Label}}</text>
+<text x="{{.ValueX}}" y="14">{{.Value}}</text>
+</g>
+</svg>`)
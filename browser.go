@@ -0,0 +1,77 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// browserDisplay returns the go-source display string for a repo viewed
+// through a self-hosted source browser. home is the repo's home page and
+// browserURL is the browser's page for the repo, which defaults to home for
+// browsers that serve repos at their clone URL.
+func browserDisplay(browser, home, browserURL, branch string) (string, error) {
+	if browserURL == "" {
+		if browser != "cgit" && browser != "gitea" {
+			return "", fmt.Errorf("browser %q requires browser_url", browser)
+		}
+		browserURL = strings.TrimSuffix(home, ".git")
+	}
+	browserURL = strings.TrimSuffix(browserURL, "/")
+	switch browser {
+	case "cgit":
+		// https://git.example.com/cgit/project
+		return fmt.Sprintf("%v %v/tree{/dir}?h=%v %v/tree{/dir}/{file}?h=%v#n{line}", home, browserURL, branch, browserURL, branch), nil
+	case "gitea":
+		// https://git.example.com/org/project, on Gitea or Forgejo
+		return fmt.Sprintf("%v %v/src/branch/%v{/dir} %v/src/branch/%v{/dir}/{file}#L{line}", home, browserURL, branch, browserURL, branch), nil
+	case "gitweb":
+		// https://git.example.com/gitweb/?p=project.git
+		u, err := url.Parse(browserURL)
+		if err != nil {
+			return "", fmt.Errorf("browser_url: %v", err)
+		}
+		project := u.Query().Get("p")
+		if project == "" {
+			return "", fmt.Errorf("browser_url %q has no p parameter", browserURL)
+		}
+		u.RawQuery = ""
+		base := u.String()
+		return fmt.Sprintf("%v %v?p=%v;a=tree;f={dir};hb=%v %v?p=%v;a=blob;f={dir}/{file};hb=%v#l{line}", home, base, project, branch, base, project, branch), nil
+	case "viewvc":
+		// https://svn.example.com/viewvc/project/trunk
+		return fmt.Sprintf("%v %v{/dir}/ %v{/dir}/{file}?view=markup#l{line}", home, browserURL, browserURL), nil
+	case "websvn":
+		// https://svn.example.com/websvn/listing.php?repname=project&path=/trunk
+		u, err := url.Parse(browserURL)
+		if err != nil {
+			return "", fmt.Errorf("browser_url: %v", err)
+		}
+		q := u.Query()
+		repname, path := q.Get("repname"), strings.TrimSuffix(q.Get("path"), "/")
+		if repname == "" {
+			return "", fmt.Errorf("browser_url %q has no repname parameter", browserURL)
+		}
+		u.RawQuery = ""
+		u.Path = u.Path[:strings.LastIndex(u.Path, "/")+1]
+		base := u.String()
+		query := "repname=" + url.QueryEscape(repname) + "&path=" + path
+		return fmt.Sprintf("%v %vlisting.php?%v{/dir}/ %vfiledetails.php?%v{/dir}/{file}#l{line}", home, base, query, base, query), nil
+	default:
+		return "", fmt.Errorf("unknown browser %q", browser)
+	}
+}
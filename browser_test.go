@@ -0,0 +1,106 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestBrowserDisplay(t *testing.T) {
+	tests := []struct {
+		browser    string
+		home       string
+		browserURL string
+		want       string
+		wantErr    bool
+	}{
+		{
+			browser: "cgit",
+			home:    "https://git.example.com/portmidi",
+			want:    "https://git.example.com/portmidi https://git.example.com/portmidi/tree{/dir}?h=main https://git.example.com/portmidi/tree{/dir}/{file}?h=main#n{line}",
+		},
+		{
+			browser: "cgit",
+			home:    "https://git.example.com/portmidi.git",
+			want:    "https://git.example.com/portmidi.git https://git.example.com/portmidi/tree{/dir}?h=main https://git.example.com/portmidi/tree{/dir}/{file}?h=main#n{line}",
+		},
+		{
+			browser:    "cgit",
+			home:       "https://git.example.com/portmidi.git",
+			browserURL: "https://git.example.com/cgit/portmidi/",
+			want:       "https://git.example.com/portmidi.git https://git.example.com/cgit/portmidi/tree{/dir}?h=main https://git.example.com/cgit/portmidi/tree{/dir}/{file}?h=main#n{line}",
+		},
+		{
+			browser: "gitea",
+			home:    "https://git.example.com/org/portmidi",
+			want:    "https://git.example.com/org/portmidi https://git.example.com/org/portmidi/src/branch/main{/dir} https://git.example.com/org/portmidi/src/branch/main{/dir}/{file}#L{line}",
+		},
+		{
+			browser:    "gitweb",
+			home:       "https://git.example.com/portmidi.git",
+			browserURL: "https://git.example.com/gitweb/?p=portmidi.git",
+			want:       "https://git.example.com/portmidi.git https://git.example.com/gitweb/?p=portmidi.git;a=tree;f={dir};hb=main https://git.example.com/gitweb/?p=portmidi.git;a=blob;f={dir}/{file};hb=main#l{line}",
+		},
+		{
+			browser:    "gitweb",
+			home:       "https://git.example.com/portmidi.git",
+			browserURL: "https://git.example.com/gitweb/",
+			wantErr:    true,
+		},
+		{
+			browser:    "viewvc",
+			home:       "https://svn.example.com/repos/portmidi/trunk",
+			browserURL: "https://svn.example.com/viewvc/portmidi/trunk/",
+			want:       "https://svn.example.com/repos/portmidi/trunk https://svn.example.com/viewvc/portmidi/trunk{/dir}/ https://svn.example.com/viewvc/portmidi/trunk{/dir}/{file}?view=markup#l{line}",
+		},
+		{
+			browser:    "websvn",
+			home:       "https://svn.example.com/repos/portmidi/trunk",
+			browserURL: "https://svn.example.com/websvn/listing.php?repname=portmidi&path=/trunk/",
+			want:       "https://svn.example.com/repos/portmidi/trunk https://svn.example.com/websvn/listing.php?repname=portmidi&path=/trunk{/dir}/ https://svn.example.com/websvn/filedetails.php?repname=portmidi&path=/trunk{/dir}/{file}#l{line}",
+		},
+		{
+			browser:    "websvn",
+			home:       "https://svn.example.com/repos/portmidi/trunk",
+			browserURL: "https://svn.example.com/websvn/listing.php?path=/trunk",
+			wantErr:    true,
+		},
+		{
+			browser: "viewvc",
+			home:    "https://svn.example.com/repos/portmidi/trunk",
+			wantErr: true,
+		},
+		{
+			browser:    "sourcehut",
+			home:       "https://git.example.com/portmidi",
+			browserURL: "https://git.example.com/portmidi",
+			wantErr:    true,
+		},
+	}
+	for _, test := range tests {
+		got, err := browserDisplay(test.browser, test.home, test.browserURL, "main")
+		if err != nil {
+			if !test.wantErr {
+				t.Errorf("browserDisplay(%q, %q, %q, \"main\"): %v", test.browser, test.home, test.browserURL, err)
+			}
+			continue
+		}
+		if test.wantErr {
+			t.Errorf("browserDisplay(%q, %q, %q, \"main\") = %q; want error", test.browser, test.home, test.browserURL, got)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("browserDisplay(%q, %q, %q, \"main\") = %q; want %q", test.browser, test.home, test.browserURL, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build caddy
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	_ "github.com/caddyserver/caddy/v2/modules/standard"
+	"gopkg.in/yaml.v2"
+)
+
+// Built with the caddy tag, the app is a Caddy server with a govanityurls
+// handler added, so that Caddy serves the paths along with everything
+// else it serves.
+func init() {
+	caddy.RegisterModule(caddyHandler{})
+	httpcaddyfile.RegisterHandlerDirective("govanityurls", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("govanityurls", httpcaddyfile.Before, "file_server")
+}
+
+func main() {
+	caddycmd.Main()
+}
+
+// caddyHandler is the http.handlers.govanityurls Caddy module. It serves
+// the paths of the config file named by Config, or else those given in
+// Paths, and passes other requests on to the next handler.
+type caddyHandler struct {
+	Config string               `json:"config,omitempty"`
+	Paths  map[string]caddyPath `json:"paths,omitempty"`
+}
+
+// caddyPath is a path given in the Caddy config.
+type caddyPath struct {
+	Repo string `json:"repo"`
+	VCS  string `json:"vcs,omitempty"`
+}
+
+func (caddyHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.govanityurls",
+		New: func() caddy.Module { return new(caddyHandler) },
+	}
+}
+
+// caddyMu keeps handlers from loading their configs at the same time, as
+// the config is shared by the whole process.
+var caddyMu sync.Mutex
+
+// Provision loads the config of v. There is only one config, so a Caddy
+// server should have only one govanityurls handler.
+func (v *caddyHandler) Provision(caddy.Context) error {
+	caddyMu.Lock()
+	defer caddyMu.Unlock()
+	file := v.Config
+	if file == "" {
+		paths := make(map[string]pathConfig)
+		for path, p := range v.Paths {
+			paths[path] = pathConfig{Repo: p.Repo, VCS: p.VCS}
+		}
+		data, err := yaml.Marshal(struct {
+			Paths map[string]pathConfig `yaml:"paths"`
+		}{paths})
+		if err != nil {
+			return err
+		}
+		f, err := os.CreateTemp("", "vanity*.yaml")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		file = f.Name()
+	}
+	loadConfig(file)
+	isReady.Store(true)
+	return nil
+}
+
+func (v *caddyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if !servesPath(r) {
+		return next.ServeHTTP(w, r)
+	}
+	handle(w, r)
+	return nil
+}
+
+// servesPath reports whether r is for one of the configured paths.
+func servesPath(r *http.Request) bool {
+	h, _, ok := hostFor(r)
+	if !ok {
+		return false
+	}
+	if pathPrefix != "" {
+		if r, ok = stripPathPrefix(r); !ok {
+			return false
+		}
+	}
+	_, _, ok = h.findPath(r.URL.Path)
+	return ok
+}
+
+// parseCaddyfile sets up the handler for a govanityurls directive:
+//
+//	govanityurls [<config file>] {
+//		config <config file>
+//		path <path> <repo> [<vcs>]
+//	}
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	v := new(caddyHandler)
+	for h.Next() {
+		if h.NextArg() {
+			v.Config = h.Val()
+		}
+		if h.NextArg() {
+			return nil, h.ArgErr()
+		}
+		for h.NextBlock(0) {
+			switch h.Val() {
+			case "config":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				v.Config = h.Val()
+			case "path":
+				args := h.RemainingArgs()
+				if len(args) != 2 && len(args) != 3 {
+					return nil, h.ArgErr()
+				}
+				e := caddyPath{Repo: args[1]}
+				if len(args) == 3 {
+					e.VCS = args[2]
+				}
+				if v.Paths == nil {
+					v.Paths = make(map[string]caddyPath)
+				}
+				v.Paths[args[0]] = e
+			default:
+				return nil, h.Errf("unknown govanityurls setting %q", h.Val())
+			}
+		}
+	}
+	if v.Config != "" && len(v.Paths) > 0 {
+		return nil, h.Errf("govanityurls takes either a config file or paths, not both")
+	}
+	return v, nil
+}
+
+// defaultHost returns the host that a trusted proxy forwarded r for, or
+// else the host r was sent to.
+func defaultHost(r *http.Request) string {
+	return requestHost(r)
+}
+
+var (
+	_ caddy.Provisioner           = (*caddyHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*caddyHandler)(nil)
+)
@@ -0,0 +1,120 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minGzipSize is the smallest body worth compressing.
+const minGzipSize = 256
+
+// maxGzipCacheEntries bounds the number of compressed bodies kept. Pages
+// are few (a handful per path), so this is only reached when there are
+// many requests for subpaths, and then the least recently used are
+// dropped.
+const maxGzipCacheEntries = 4096
+
+// gzipCache holds compressed response bodies by the ETag of their
+// uncompressed body, which identifies it, so that each distinct page is
+// compressed only once while it stays in the cache. The cache is emptied
+// whenever the config is loaded, since the pages change with it.
+var gzipCache struct {
+	mu sync.Mutex
+	m  map[string]*list.Element
+	// lru lists the entries, most recently used first.
+	lru list.List
+}
+
+// gzipWriters holds writers for reuse, since each is costly to set up.
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+type gzipEntry struct {
+	etag string
+	z    []byte
+}
+
+// gzipBody returns body, whose ETag is etag, compressed with gzip, reusing
+// an earlier result for the same body when there is one.
+func gzipBody(etag string, body []byte) []byte {
+	gzipCache.mu.Lock()
+	if e, ok := gzipCache.m[etag]; ok {
+		gzipCache.lru.MoveToFront(e)
+		gzipCache.mu.Unlock()
+		return e.Value.(*gzipEntry).z
+	}
+	gzipCache.mu.Unlock()
+
+	var buf bytes.Buffer
+	zw := gzipWriters.Get().(*gzip.Writer)
+	zw.Reset(&buf)
+	zw.Write(body)
+	zw.Close()
+	gzipWriters.Put(zw)
+	z := buf.Bytes()
+
+	gzipCache.mu.Lock()
+	defer gzipCache.mu.Unlock()
+	if e, ok := gzipCache.m[etag]; ok {
+		// Another request compressed it meanwhile.
+		return e.Value.(*gzipEntry).z
+	}
+	if gzipCache.m == nil {
+		gzipCache.m = make(map[string]*list.Element)
+	}
+	gzipCache.m[etag] = gzipCache.lru.PushFront(&gzipEntry{etag, z})
+	if gzipCache.lru.Len() > maxGzipCacheEntries {
+		oldest := gzipCache.lru.Back()
+		gzipCache.lru.Remove(oldest)
+		delete(gzipCache.m, oldest.Value.(*gzipEntry).etag)
+	}
+	return z
+}
+
+// clearGzipCache empties gzipCache.
+func clearGzipCache() {
+	gzipCache.mu.Lock()
+	gzipCache.m = nil
+	gzipCache.lru.Init()
+	gzipCache.mu.Unlock()
+}
+
+// acceptsGzip reports whether r's Accept-Encoding header allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(enc, ";")
+		if name := strings.TrimSpace(params[0]); name != "gzip" && name != "*" {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
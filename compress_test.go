@@ -0,0 +1,86 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteResponseReusesGzip(t *testing.T) {
+	clearGzipCache()
+	t.Cleanup(clearGzipCache)
+	body := []byte(strings.Repeat("<p>Nothing to see here.</p>\n", 20))
+	var first []byte
+	// The Host and path are up to the client, and don't change the body.
+	for i := 0; i < 10; i++ {
+		r := httptest.NewRequest("GET", "http://junk"+strconv.Itoa(i)+".example.com/x/"+strconv.Itoa(i), nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		writeResponse(w, r, http.StatusOK, "text/html; charset=utf-8", body)
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("request %d: Content-Encoding = %q; want gzip", i, w.Header().Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, body) {
+			t.Errorf("request %d: body = %q, %v; want %q", i, got, err, body)
+		}
+		if i == 0 {
+			first = w.Body.Bytes()
+		} else if !bytes.Equal(w.Body.Bytes(), first) {
+			t.Errorf("request %d: compressed body differs from the first", i)
+		}
+	}
+	if n := len(gzipCache.m); n != 1 {
+		t.Errorf("gzip cache has %d entries after requests for one body; want 1", n)
+	}
+	a, b := gzipBody("etag", body), gzipBody("etag", body)
+	if &a[0] != &b[0] {
+		t.Error("gzipBody compressed the same body twice")
+	}
+}
+
+func TestGzipCacheEvicts(t *testing.T) {
+	clearGzipCache()
+	t.Cleanup(clearGzipCache)
+	body := []byte("body")
+	first := gzipBody("0", body)
+	for i := 1; i <= maxGzipCacheEntries; i++ {
+		if i == maxGzipCacheEntries/2 {
+			// Keep the first entry in use, so that the second is the
+			// least recently used.
+			gzipBody("0", body)
+		}
+		gzipBody(strconv.Itoa(i), body)
+	}
+	if n := len(gzipCache.m); n != maxGzipCacheEntries {
+		t.Errorf("gzip cache has %d entries; want %d", n, maxGzipCacheEntries)
+	}
+	if _, ok := gzipCache.m["1"]; ok {
+		t.Error("least recently used entry wasn't evicted")
+	}
+	if z := gzipBody("0", body); &z[0] != &first[0] {
+		t.Error("recently used entry was evicted")
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsConfig controls the CORS headers sent with JSON responses.
+type corsConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins,omitempty"`
+	AllowedMethods []string `yaml:"allowed_methods,omitempty"`
+	MaxAge         int      `yaml:"max_age,omitempty"`
+}
+
+var cors corsConfig
+
+// isJSONEndpoint reports whether path only ever serves JSON, and so may
+// be the target of a CORS preflight request.
+func isJSONEndpoint(path string) bool {
+	return path == "/index.json" || path == apiPathsPrefix || strings.HasPrefix(path, apiPathsPrefix+"/")
+}
+
+// setCORSHeaders allows the request's origin to read the response, if it
+// is one of the configured origins.
+func setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
+	if len(cors.AllowedOrigins) == 0 {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	for _, o := range cors.AllowedOrigins {
+		if o == "*" {
+			h.Set("Access-Control-Allow-Origin", "*")
+			return true
+		}
+		if strings.EqualFold(o, origin) {
+			h.Set("Access-Control-Allow-Origin", origin)
+			return true
+		}
+	}
+	return false
+}
+
+// serveCORSPreflight answers an OPTIONS request for a JSON endpoint.
+func serveCORSPreflight(w http.ResponseWriter, r *http.Request) {
+	methods := cors.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD"}
+	}
+	w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+	if setCORSHeaders(w, r) {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if hdrs := r.Header.Get("Access-Control-Request-Headers"); hdrs != "" {
+			w.Header().Set("Access-Control-Allow-Headers", hdrs)
+		}
+		if cors.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// setupCORS serves c as the CORS config for the rest of the test.
+func setupCORS(t *testing.T, c corsConfig) {
+	t.Helper()
+	old := cors
+	t.Cleanup(func() { cors = old })
+	cors = c
+}
+
+func TestCORSPreflight(t *testing.T) {
+	setupHost(t, &hostConfig{Paths: map[string]pathConfig{
+		"/portmidi": {Repo: "https://github.com/rakyll/portmidi"},
+	}})
+	setupCORS(t, corsConfig{
+		AllowedOrigins: []string{"https://docs.example.com"},
+		AllowedMethods: []string{"GET"},
+		MaxAge:         600,
+	})
+	preflight := http.Header{
+		"Access-Control-Request-Method":  {"GET"},
+		"Access-Control-Request-Headers": {"X-Requested-With"},
+	}
+	tests := []struct {
+		name   string
+		path   string
+		origin string
+		code   int
+		want   map[string]string
+	}{
+		{
+			name:   "allowed origin",
+			path:   "/index.json",
+			origin: "https://docs.example.com",
+			code:   http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://docs.example.com",
+				"Access-Control-Allow-Methods": "GET",
+				"Access-Control-Allow-Headers": "X-Requested-With",
+				"Access-Control-Max-Age":       "600",
+				"Allow":                        "GET, HEAD, OPTIONS",
+				"Vary":                         "Origin",
+			},
+		},
+		{
+			name:   "allowed origin in another case",
+			path:   apiPathsPrefix + "/portmidi",
+			origin: "https://DOCS.example.com",
+			code:   http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://DOCS.example.com",
+				"Access-Control-Allow-Headers": "X-Requested-With",
+			},
+		},
+		{
+			name:   "other origin",
+			path:   "/index.json",
+			origin: "https://evil.example.com",
+			code:   http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+				"Access-Control-Allow-Headers": "",
+				"Access-Control-Max-Age":       "",
+				"Allow":                        "GET, HEAD, OPTIONS",
+				"Vary":                         "Origin",
+			},
+		},
+		{
+			name: "no origin",
+			path: apiPathsPrefix,
+			code: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Headers": "",
+			},
+		},
+		{
+			name:   "not a JSON endpoint",
+			path:   "/portmidi",
+			origin: "https://docs.example.com",
+			code:   http.StatusMethodNotAllowed,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Headers": "",
+				"Allow":                        "GET, HEAD",
+			},
+		},
+	}
+	for _, test := range tests {
+		header := preflight.Clone()
+		if test.origin != "" {
+			header.Set("Origin", test.origin)
+		}
+		w := sendRequest(handle, "OPTIONS", test.path, header, "")
+		if w.Code != test.code {
+			t.Errorf("%s: OPTIONS %s = %d; want %d", test.name, test.path, w.Code, test.code)
+		}
+		for k, want := range test.want {
+			if got := w.Header().Get(k); got != want {
+				t.Errorf("%s: OPTIONS %s %s = %q; want %q", test.name, test.path, k, got, want)
+			}
+		}
+	}
+}
+
+func TestCORSPreflightWildcard(t *testing.T) {
+	setupHost(t, &hostConfig{Paths: map[string]pathConfig{
+		"/portmidi": {Repo: "https://github.com/rakyll/portmidi"},
+	}})
+	setupCORS(t, corsConfig{AllowedOrigins: []string{"*"}})
+	header := http.Header{
+		"Origin":                         {"https://docs.example.com"},
+		"Access-Control-Request-Method":  {"GET"},
+		"Access-Control-Request-Headers": {"X-Requested-With"},
+	}
+	w := sendRequest(handle, "OPTIONS", "/index.json", header, "")
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "GET, HEAD",
+		"Access-Control-Allow-Headers": "X-Requested-With",
+		"Access-Control-Max-Age":       "",
+	}
+	if w.Code != http.StatusNoContent {
+		t.Errorf("OPTIONS /index.json = %d; want %d", w.Code, http.StatusNoContent)
+	}
+	for k, v := range want {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("OPTIONS /index.json %s = %q; want %q", k, got, v)
+		}
+	}
+}
+
+func TestCORSHeadersOnJSON(t *testing.T) {
+	setupHost(t, &hostConfig{Paths: map[string]pathConfig{
+		"/portmidi": {Repo: "https://github.com/rakyll/portmidi"},
+	}})
+	setupCORS(t, corsConfig{AllowedOrigins: []string{"https://docs.example.com"}})
+	tests := []struct {
+		path   string
+		origin string
+		want   string
+	}{
+		{"/index.json", "https://docs.example.com", "https://docs.example.com"},
+		{"/index.json", "https://evil.example.com", ""},
+		{"/index.json", "", ""},
+		{apiPathsPrefix + "/portmidi", "https://docs.example.com", "https://docs.example.com"},
+		{apiPathsPrefix + "/missing", "https://docs.example.com", "https://docs.example.com"},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.origin != "" {
+			header.Set("Origin", test.origin)
+		}
+		w := sendRequest(handle, "GET", test.path, header, "")
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.want {
+			t.Errorf("GET %s from %q: Access-Control-Allow-Origin = %q; want %q", test.path, test.origin, got, test.want)
+		}
+		if vary := strings.Join(w.Header().Values("Vary"), ", "); !strings.Contains(vary, "Origin") {
+			t.Errorf("GET %s from %q: Vary = %q; want it to include Origin", test.path, test.origin, vary)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+// debugPrefix is the path under which the debugging endpoints are served.
+const debugPrefix = "/-/debug/"
+
+// debugHandler serves the debugging endpoints, if any are turned on.
+var debugHandler http.Handler
+
+// serveDebugRender shows how the request for the path in the path query
+// parameter would be handled: the configured path it matches, with its
+// settings, the subpath, and the response, including the meta tags the go
+// command gets. The host is given in the host parameter, or else taken
+// from r, and the response is rendered for browsers unless go-get is 1.
+func serveDebugRender(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	path := q.Get("path")
+	if !strings.HasPrefix(path, "/") {
+		writeJSONError(w, r, http.StatusBadRequest, "path must start with a slash")
+		return
+	}
+	h, host, ok := hostFor(r)
+	if name := q.Get("host"); name != "" {
+		h, ok = nil, false
+		for _, vh := range hosts {
+			if strings.EqualFold(vh.host, name) {
+				h, ok = vh, true
+			}
+		}
+		if !ok && hosts[0].host == "" {
+			h, ok = hosts[0], true
+		}
+		host = name
+	}
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, "unknown host")
+		return
+	}
+	result := debugRenderResult{Host: host, Path: path}
+	if matched, subpath, ok := h.findPath(path); ok {
+		e := h.pathMap()[matched]
+		result.Matched = matched
+		result.Subpath = subpath
+		result.Import = host + pathPrefix + matched
+		result.Config = &debugPathConfig{
+			Repo:         e.Repo,
+			VCS:          e.VCS,
+			Display:      e.Display,
+			Branch:       e.Branch,
+			Proxy:        e.Proxy,
+			Redirect:     e.Redirect,
+			RedirectMode: e.RedirectMode,
+			Browser:      e.Browser,
+			BrowserURL:   e.BrowserURL,
+			Headers:      e.Headers,
+		}
+	}
+	u := &url.URL{Path: pathPrefix + path}
+	if q.Get("go-get") == "1" {
+		u.RawQuery = "go-get=1"
+	}
+	req := httptest.NewRequest("GET", u.RequestURI(), nil).WithContext(r.Context())
+	req.Host = host
+	req.RemoteAddr = r.RemoteAddr
+	rec := httptest.NewRecorder()
+	handle(rec, withoutObservation(req))
+	result.Status = rec.Code
+	result.Headers = rec.Header()
+	result.Body = rec.Body.String()
+	result.GoImport = metaContents(strings.NewReader(result.Body), "go-import")
+	result.GoSource = metaContents(strings.NewReader(result.Body), "go-source")
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+// debugRenderResult is the response of serveDebugRender.
+type debugRenderResult struct {
+	Host     string           `json:"host"`
+	Path     string           `json:"path"`
+	Matched  string           `json:"matched,omitempty"`
+	Subpath  string           `json:"subpath,omitempty"`
+	Import   string           `json:"import,omitempty"`
+	Config   *debugPathConfig `json:"config,omitempty"`
+	Status   int              `json:"status"`
+	Headers  http.Header      `json:"headers"`
+	GoImport []string         `json:"go_import"`
+	GoSource []string         `json:"go_source"`
+	Body     string           `json:"body"`
+}
+
+// debugPathConfig is the part of a path's settings, as filled in when the
+// config was loaded, that decides how it is served.
+type debugPathConfig struct {
+	Repo         string            `json:"repo"`
+	VCS          string            `json:"vcs"`
+	Display      string            `json:"display,omitempty"`
+	Branch       string            `json:"branch,omitempty"`
+	Proxy        bool              `json:"proxy,omitempty"`
+	Redirect     string            `json:"redirect,omitempty"`
+	RedirectMode string            `json:"redirect_mode,omitempty"`
+	Browser      string            `json:"browser,omitempty"`
+	BrowserURL   string            `json:"browser_url,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+}
+
+// metaContents returns the content of each meta tag called name in the
+// head of the HTML page read from r, parsing it as leniently as the go
+// command does.
+func metaContents(r io.Reader, name string) []string {
+	d := xml.NewDecoder(r)
+	d.Strict, d.AutoClose, d.Entity = false, xml.HTMLAutoClose, xml.HTMLEntity
+	var contents []string
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return contents
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return contents
+		}
+		start, ok := t.(xml.StartElement)
+		if ok && strings.EqualFold(start.Name.Local, "meta") && attrValue(start.Attr, "name") == name {
+			contents = append(contents, attrValue(start.Attr, "content"))
+		}
+	}
+}
+
+// attrValue returns the value of the attribute called name, or empty if
+// there is none.
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
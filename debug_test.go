@@ -0,0 +1,56 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServeDebugRenderUnobserved(t *testing.T) {
+	setupHost(t, &hostConfig{Host: "go.example.com", Paths: map[string]pathConfig{
+		"/portmidi": {Repo: "https://github.com/rakyll/portmidi"},
+	}})
+	// A client that has used up its requests can still be shown how they
+	// would be served.
+	limiter = newRateLimiter(1, 1)
+	target := debugPrefix + "render?path=/portmidi/sub&go-get=1"
+	limiter.allow(clientIP(httptest.NewRequest("GET", "http://go.example.com"+target, nil)), time.Now())
+
+	requests, hits := stats.requests.Load(), pathHits()["go.example.com/portmidi"]
+	w := sendRequest(serveDebugRender, "GET", target, nil, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; want %d", w.Code, http.StatusOK)
+	}
+	var result debugRenderResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != http.StatusOK || result.Matched != "/portmidi" || result.Subpath != "sub" {
+		t.Errorf("render = status %d, matched %q, subpath %q; want status %d, matched /portmidi, subpath sub", result.Status, result.Matched, result.Subpath, http.StatusOK)
+	}
+	if want := []string{"go.example.com/portmidi git https://github.com/rakyll/portmidi"}; len(result.GoImport) != 1 || result.GoImport[0] != want[0] {
+		t.Errorf("go-import = %q; want %q", result.GoImport, want)
+	}
+	if n := stats.requests.Load(); n != requests {
+		t.Errorf("requests counted went from %d to %d; want no change", requests, n)
+	}
+	if n := pathHits()["go.example.com/portmidi"]; n != hits {
+		t.Errorf("hits on go.example.com/portmidi went from %d to %d; want no change", hits, n)
+	}
+}
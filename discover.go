@@ -0,0 +1,702 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// defaultDiscoverRefresh is how often repos are listed again, unless
+// configured otherwise.
+const defaultDiscoverRefresh = time.Hour
+
+// discoverConfig configures finding paths among the repos of an
+// organization on a code host.
+type discoverConfig struct {
+	// GitHub is the organization, or user, whose repos are listed.
+	GitHub string `yaml:"github,omitempty"`
+	// GitLab is the group whose projects, including those of its
+	// subgroups, are listed.
+	GitLab string `yaml:"gitlab,omitempty"`
+	// Bitbucket is the Bitbucket Cloud workspace whose repos are listed.
+	Bitbucket string `yaml:"bitbucket,omitempty"`
+	// Gitea is the organization, or user, on a Gitea or Forgejo instance
+	// whose repos are listed. API must be given too.
+	Gitea string `yaml:"gitea,omitempty"`
+
+	// API is the URL of the API of a self-hosted instance, like
+	// https://github.example.com/api/v3.
+	API string `yaml:"api,omitempty"`
+	// Token authenticates to the API. If empty, the token for the code
+	// host is read from the environment, as when detecting branches.
+	Token string `yaml:"token,omitempty"`
+
+	// Topics, if set, limits the repos to those with any of them.
+	Topics []string `yaml:"topics,omitempty"`
+	// Include, if set, limits the repos to those whose names match any of
+	// its patterns, and Exclude leaves out those whose names match any of
+	// its. Patterns are as for path.Match.
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Private repos, which include internal ones, archived repos, and
+	// forks are left out unless these are set.
+	IncludePrivate  bool `yaml:"include_private,omitempty"`
+	IncludeArchived bool `yaml:"include_archived,omitempty"`
+	IncludeForks    bool `yaml:"include_forks,omitempty"`
+
+	// Path is a template for the path of a repo, given a discoveredRepo.
+	// It is "/{{.Name}}" by default.
+	Path string `yaml:"path,omitempty"`
+	// Refresh is how often the repos are listed again.
+	Refresh time.Duration `yaml:"refresh,omitempty"`
+	// WebhookSecret is the secret that webhooks reporting changes to the
+	// repos are sent with. Webhooks are only accepted if it is set.
+	WebhookSecret string `yaml:"webhook_secret,omitempty"`
+}
+
+// discoveredRepo is a repo found by discovery.
+type discoveredRepo struct {
+	// Name is the name of the repo, and FullName its path on the code
+	// host, like "org/name". Subpath is its path under the organization,
+	// which is its name unless it is in a GitLab subgroup.
+	Name     string
+	FullName string
+	Subpath  string
+	// URL is the HTTPS URL of the repo.
+	URL           string
+	Description   string
+	Topics        []string
+	DefaultBranch string
+	// Private is set for repos that aren't public, Archived for those
+	// that are read-only, and Fork for forks of other repos.
+	Private  bool
+	Archived bool
+	Fork     bool
+}
+
+// discoverSource is a place paths are discovered, for a single host.
+type discoverSource struct {
+	// name describes the source in the log, like "github:org".
+	name    string
+	config  discoverConfig
+	list    func(context.Context, *discoverConfig) ([]discoveredRepo, error)
+	pathFor *texttemplate.Template
+	// browser is the source browser of the repos, if the code host
+	// isn't otherwise recognized.
+	browser string
+
+	// paths are the paths found the last time the repos were listed, or
+	// since then through webhooks, and repoPaths maps the lowercased full
+	// name of each repo among them to its path.
+	paths     map[string]pathConfig
+	repoPaths map[string]string
+
+	// webhookSecret verifies the webhooks sent for the repos.
+	webhookSecret string
+}
+
+// newDiscoverSource checks c and returns the source it describes.
+func newDiscoverSource(c discoverConfig) (*discoverSource, error) {
+	s := &discoverSource{config: c, webhookSecret: c.WebhookSecret}
+	switch {
+	case c.GitHub != "":
+		s.name, s.list = "github:"+c.GitHub, listGitHubRepos
+	case c.GitLab != "":
+		s.name, s.list = "gitlab:"+c.GitLab, listGitLabRepos
+	case c.Bitbucket != "":
+		s.name, s.list = "bitbucket:"+c.Bitbucket, listBitbucketRepos
+	case c.Gitea != "":
+		if c.API == "" {
+			return nil, fmt.Errorf("discover gitea:%s: api is required", c.Gitea)
+		}
+		s.name, s.list, s.browser = "gitea:"+c.Gitea, listGiteaRepos, "gitea"
+	default:
+		return nil, errors.New("discover: no code host given")
+	}
+	for _, pattern := range append(c.Include, c.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("discover %s: bad pattern %q", s.name, pattern)
+		}
+	}
+	pathTmpl := c.Path
+	if pathTmpl == "" {
+		pathTmpl = "/{{.Name}}"
+	}
+	var err error
+	s.pathFor, err = texttemplate.New("path").Parse(pathTmpl)
+	if err != nil {
+		return nil, fmt.Errorf("discover %s: path: %v", s.name, err)
+	}
+	if s.config.Refresh <= 0 {
+		s.config.Refresh = defaultDiscoverRefresh
+	}
+	return s, nil
+}
+
+// wants reports whether repo passes the filters of the source.
+func (s *discoverSource) wants(repo discoveredRepo) bool {
+	match := func(patterns []string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, repo.Name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case repo.Private && !s.config.IncludePrivate,
+		repo.Archived && !s.config.IncludeArchived,
+		repo.Fork && !s.config.IncludeForks:
+		return false
+	}
+	if len(s.config.Include) > 0 && !match(s.config.Include) {
+		return false
+	}
+	if match(s.config.Exclude) {
+		return false
+	}
+	if len(s.config.Topics) == 0 {
+		return true
+	}
+	for _, want := range s.config.Topics {
+		for _, t := range repo.Topics {
+			if strings.EqualFold(t, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// entry returns the path and entry for repo on host.
+func (s *discoverSource) entry(host string, repo discoveredRepo) (string, pathConfig, error) {
+	var sb strings.Builder
+	if err := s.pathFor.Execute(&sb, repo); err != nil {
+		return "", pathConfig{}, err
+	}
+	p := "/" + strings.Trim(sb.String(), "/")
+	if !validEditPath(p) {
+		return "", pathConfig{}, fmt.Errorf("invalid path %q", p)
+	}
+	e := expandPathRepo(pathConfig{
+		Repo:        repo.URL,
+		Branch:      repo.DefaultBranch,
+		Description: repo.Description,
+		Browser:     s.browser,
+	})
+	e, err := resolvePath(host, p, e)
+	return p, e, err
+}
+
+// refresh lists the repos of s again, and updates the paths of h.
+func (h *vanityHost) refresh(ctx context.Context, s *discoverSource) error {
+	repos, err := s.list(ctx, &s.config)
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]pathConfig)
+	repoPaths := make(map[string]string)
+	for _, repo := range repos {
+		if !s.wants(repo) {
+			continue
+		}
+		p, e, err := s.entry(h.host, repo)
+		if err != nil {
+			slog.Warn("cannot serve discovered repo", "source", s.name, "repo", repo.FullName, "err", err)
+			continue
+		}
+		paths[p] = e
+		repoPaths[strings.ToLower(repo.FullName)] = p
+	}
+	h.pathsMu.Lock()
+	s.paths, s.repoPaths = paths, repoPaths
+	h.pathsMu.Unlock()
+	h.mergePaths()
+	slog.Info("discovered paths", "source", s.name, "host", h.host, "paths", len(paths))
+	return nil
+}
+
+// updateRepo updates the paths of h for a change to a repo of s reported
+// by a webhook: the path of the repo called oldName, if any, is dropped,
+// and repo is added, if it isn't nil and passes the filters of s. It
+// reports whether the paths of s changed.
+func (h *vanityHost) updateRepo(s *discoverSource, oldName string, repo *discoveredRepo) (bool, error) {
+	var (
+		p   string
+		e   pathConfig
+		err error
+	)
+	if repo != nil && s.wants(*repo) {
+		p, e, err = s.entry(h.host, *repo)
+		if err != nil {
+			return false, err
+		}
+	}
+	h.pathsMu.Lock()
+	if s.paths == nil {
+		s.paths, s.repoPaths = make(map[string]pathConfig), make(map[string]string)
+	}
+	changed := p != ""
+	for _, name := range []string{oldName, repoName(repo)} {
+		if old, ok := s.repoPaths[strings.ToLower(name)]; ok {
+			delete(s.paths, old)
+			delete(s.repoPaths, strings.ToLower(name))
+			changed = true
+		}
+	}
+	if p != "" {
+		s.paths[p] = e
+		s.repoPaths[strings.ToLower(repo.FullName)] = p
+	}
+	h.pathsMu.Unlock()
+	if changed {
+		h.mergePaths()
+	}
+	return changed, nil
+}
+
+// repoName returns the full name of repo, or empty if it is nil.
+func repoName(repo *discoveredRepo) string {
+	if repo == nil {
+		return ""
+	}
+	return repo.FullName
+}
+
+// mergePaths updates the paths served on h to the configured paths
+// along with those added through the paths API and those discovered.
+// Configured paths come first, then those added through the API, then
+// those of the sources in order.
+func (h *vanityHost) mergePaths() {
+	h.pathsMu.Lock()
+	defer h.pathsMu.Unlock()
+	merged := make(map[string]pathConfig, len(h.paths)+len(h.managed))
+	for p, e := range h.paths {
+		merged[p] = e
+	}
+	for p, e := range h.managed {
+		if _, ok := merged[p]; !ok {
+			merged[p] = e
+		}
+	}
+	for _, s := range h.discover {
+		for p, e := range s.paths {
+			if _, ok := merged[p]; !ok {
+				merged[p] = e
+			}
+		}
+	}
+	h.served.Store(&merged)
+}
+
+// startDiscovery lists the repos of each of the sources of h, then keeps
+// listing them again in the background until stopDiscovery is called.
+// Paths found by sources that fail the first time are missing until they
+// succeed.
+func (h *vanityHost) startDiscovery() {
+	if len(h.discover) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancelDiscovery = cancel
+	var wg sync.WaitGroup
+	for _, s := range h.discover {
+		wg.Add(1)
+		go func(s *discoverSource) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			if err := h.refresh(ctx, s); err != nil {
+				slog.Error("discovery failed", "source", s.name, "err", err)
+			}
+		}(s)
+	}
+	wg.Wait()
+	for _, s := range h.discover {
+		go func(s *discoverSource) {
+			ticker := time.NewTicker(s.config.Refresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				ctx, cancel := context.WithTimeout(ctx, time.Minute)
+				if err := h.refresh(ctx, s); err != nil {
+					slog.Error("discovery failed", "source", s.name, "err", err)
+				}
+				cancel()
+			}
+		}(s)
+	}
+}
+
+// stopDiscovery stops listing the repos of the sources of h again, and
+// cancels any listing in progress.
+func (h *vanityHost) stopDiscovery() {
+	if h.cancelDiscovery != nil {
+		h.cancelDiscovery()
+	}
+}
+
+// discoverClient is used to list repos.
+var discoverClient = &http.Client{Timeout: 30 * time.Second}
+
+// errAPINotFound is returned by getJSONPages when the first page is a 404.
+var errAPINotFound = errors.New("not found")
+
+// getJSONPages gets the JSON document at apiURL, and those after it,
+// passing each to page. The URL of the next document is the one page
+// returns, or else the "next" link of the Link header.
+func getJSONPages(ctx context.Context, apiURL string, header http.Header, page func(data json.RawMessage) (next string, err error)) error {
+	for first := true; apiURL != ""; first = false {
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+		if err != nil {
+			return err
+		}
+		req.Header = header
+		resp, err := discoverClient.Do(req)
+		if err != nil {
+			return err
+		}
+		var data json.RawMessage
+		switch {
+		case resp.StatusCode == http.StatusNotFound && first:
+			err = errAPINotFound
+		case resp.StatusCode != http.StatusOK:
+			err = fmt.Errorf("%s: %s", apiURL, resp.Status)
+		default:
+			err = json.NewDecoder(resp.Body).Decode(&data)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		next, err := page(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", apiURL, err)
+		}
+		if next == "" {
+			next = nextLink(resp.Header.Get("Link"))
+		}
+		apiURL = next
+	}
+	return nil
+}
+
+// nextLink returns the URL of the "next" link in a Link header, if any.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.ReplaceAll(strings.TrimSpace(param), `"`, "") == "rel=next" {
+				return target[1 : len(target)-1]
+			}
+		}
+	}
+	return ""
+}
+
+// listGitHubRepos lists the repos of a GitHub organization, or of a user
+// if there is no organization by that name.
+func listGitHubRepos(ctx context.Context, c *discoverConfig) ([]discoveredRepo, error) {
+	api := strings.TrimSuffix(c.API, "/")
+	if api == "" {
+		api = "https://api.github.com"
+	}
+	header := make(http.Header)
+	header.Set("Accept", "application/vnd.github+json")
+	token := c.Token
+	if token == "" {
+		if u, err := url.Parse(api); err == nil {
+			token = codeHosts.GitHubToken(u.Host)
+		}
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	var repos []discoveredRepo
+	page := func(data json.RawMessage) (string, error) {
+		var results []struct {
+			Name          string   `json:"name"`
+			FullName      string   `json:"full_name"`
+			HTMLURL       string   `json:"html_url"`
+			Description   string   `json:"description"`
+			Topics        []string `json:"topics"`
+			DefaultBranch string   `json:"default_branch"`
+			Private       bool     `json:"private"`
+			Archived      bool     `json:"archived"`
+			Fork          bool     `json:"fork"`
+			// Visibility is "internal" for repos visible to any member of
+			// a GitHub Enterprise, which are no more public than private ones.
+			Visibility string `json:"visibility"`
+		}
+		if err := json.Unmarshal(data, &results); err != nil {
+			return "", err
+		}
+		for _, r := range results {
+			repos = append(repos, discoveredRepo{
+				Name:          r.Name,
+				FullName:      r.FullName,
+				Subpath:       r.Name,
+				URL:           r.HTMLURL,
+				Description:   r.Description,
+				Topics:        r.Topics,
+				DefaultBranch: r.DefaultBranch,
+				Private:       r.Private || r.Visibility != "" && r.Visibility != "public",
+				Archived:      r.Archived,
+				Fork:          r.Fork,
+			})
+		}
+		return "", nil
+	}
+	owner := url.PathEscape(c.GitHub)
+	err := getJSONPages(ctx, api+"/orgs/"+owner+"/repos?per_page=100", header, page)
+	if err == errAPINotFound {
+		err = getJSONPages(ctx, api+"/users/"+owner+"/repos?per_page=100", header, page)
+	}
+	if err == errAPINotFound {
+		err = fmt.Errorf("no GitHub organization or user %q", c.GitHub)
+	}
+	return repos, err
+}
+
+// gitlabAPI returns the URL of the API of the GitLab instance of c, and
+// the headers to send it.
+func gitlabAPI(c *discoverConfig) (string, http.Header) {
+	api := strings.TrimSuffix(c.API, "/")
+	if api == "" {
+		api = "https://gitlab.com/api/v4"
+	}
+	header := make(http.Header)
+	token := c.Token
+	if token == "" {
+		token = os.Getenv("GITLAB_TOKEN")
+	}
+	if token != "" {
+		header.Set("PRIVATE-TOKEN", token)
+	}
+	return api, header
+}
+
+// gitlabProject is a project as described by the GitLab API.
+type gitlabProject struct {
+	Name              string    `json:"name"`
+	Path              string    `json:"path"`
+	PathWithNamespace string    `json:"path_with_namespace"`
+	WebURL            string    `json:"web_url"`
+	Description       string    `json:"description"`
+	Topics            []string  `json:"topics"`
+	TagList           []string  `json:"tag_list"`
+	DefaultBranch     string    `json:"default_branch"`
+	Visibility        string    `json:"visibility"`
+	Archived          bool      `json:"archived"`
+	ForkedFrom        *struct{} `json:"forked_from_project"`
+}
+
+// repo returns the project as a repo of group.
+func (p *gitlabProject) repo(group string) discoveredRepo {
+	topics := p.Topics
+	if topics == nil {
+		// Before GitLab 14.5, topics were called tags.
+		topics = p.TagList
+	}
+	return discoveredRepo{
+		Name:          p.Path,
+		FullName:      p.PathWithNamespace,
+		Subpath:       strings.TrimPrefix(p.PathWithNamespace, group+"/"),
+		URL:           p.WebURL,
+		Description:   p.Description,
+		Topics:        topics,
+		DefaultBranch: p.DefaultBranch,
+		Private:       p.Visibility != "" && p.Visibility != "public",
+		Archived:      p.Archived,
+		Fork:          p.ForkedFrom != nil,
+	}
+}
+
+// listGitLabRepos lists the projects of a GitLab group and its subgroups.
+func listGitLabRepos(ctx context.Context, c *discoverConfig) ([]discoveredRepo, error) {
+	api, header := gitlabAPI(c)
+	group := strings.Trim(c.GitLab, "/")
+	var repos []discoveredRepo
+	err := getJSONPages(ctx, api+"/groups/"+url.PathEscape(group)+"/projects?include_subgroups=true&per_page=100", header, func(data json.RawMessage) (string, error) {
+		var results []gitlabProject
+		if err := json.Unmarshal(data, &results); err != nil {
+			return "", err
+		}
+		for i := range results {
+			repos = append(repos, results[i].repo(group))
+		}
+		return "", nil
+	})
+	if err == errAPINotFound {
+		err = fmt.Errorf("no GitLab group %q", c.GitLab)
+	}
+	return repos, err
+}
+
+// getGitLabRepo gets the project called name, a path with its namespace,
+// of the GitLab group of c. It returns nil if there is no such project.
+func getGitLabRepo(ctx context.Context, c *discoverConfig, name string) (*discoveredRepo, error) {
+	api, header := gitlabAPI(c)
+	var p gitlabProject
+	err := getJSONPages(ctx, api+"/projects/"+url.PathEscape(name), header, func(data json.RawMessage) (string, error) {
+		return "", json.Unmarshal(data, &p)
+	})
+	if err == errAPINotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	repo := p.repo(strings.Trim(c.GitLab, "/"))
+	return &repo, nil
+}
+
+// listBitbucketRepos lists the git repos of a Bitbucket Cloud workspace.
+func listBitbucketRepos(ctx context.Context, c *discoverConfig) ([]discoveredRepo, error) {
+	api := strings.TrimSuffix(c.API, "/")
+	if api == "" {
+		api = "https://api.bitbucket.org/2.0"
+	}
+	header := make(http.Header)
+	token := c.Token
+	if token == "" {
+		token = os.Getenv("BITBUCKET_TOKEN")
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	var repos []discoveredRepo
+	err := getJSONPages(ctx, api+"/repositories/"+url.PathEscape(c.Bitbucket)+"?pagelen=100", header, func(data json.RawMessage) (string, error) {
+		var results struct {
+			Values []struct {
+				Slug        string    `json:"slug"`
+				FullName    string    `json:"full_name"`
+				Description string    `json:"description"`
+				SCM         string    `json:"scm"`
+				IsPrivate   bool      `json:"is_private"`
+				Parent      *struct{} `json:"parent"`
+				Links       struct {
+					HTML struct {
+						Href string `json:"href"`
+					} `json:"html"`
+				} `json:"links"`
+				MainBranch struct {
+					Name string `json:"name"`
+				} `json:"mainbranch"`
+			} `json:"values"`
+			Next string `json:"next"`
+		}
+		if err := json.Unmarshal(data, &results); err != nil {
+			return "", err
+		}
+		for _, r := range results.Values {
+			if r.SCM != "" && r.SCM != "git" {
+				continue
+			}
+			repos = append(repos, discoveredRepo{
+				Name:          r.Slug,
+				FullName:      r.FullName,
+				Subpath:       r.Slug,
+				URL:           r.Links.HTML.Href,
+				Description:   r.Description,
+				DefaultBranch: r.MainBranch.Name,
+				Private:       r.IsPrivate,
+				Fork:          r.Parent != nil,
+			})
+		}
+		return results.Next, nil
+	})
+	if err == errAPINotFound {
+		err = fmt.Errorf("no Bitbucket workspace %q", c.Bitbucket)
+	}
+	return repos, err
+}
+
+// listGiteaRepos lists the repos of an organization, or of a user if
+// there is no organization by that name, on a Gitea or Forgejo instance.
+func listGiteaRepos(ctx context.Context, c *discoverConfig) ([]discoveredRepo, error) {
+	api := strings.TrimSuffix(c.API, "/")
+	header := make(http.Header)
+	token := c.Token
+	if token == "" {
+		token = os.Getenv("GITEA_TOKEN")
+	}
+	if token != "" {
+		header.Set("Authorization", "token "+token)
+	}
+	var repos []discoveredRepo
+	page := func(data json.RawMessage) (string, error) {
+		var results []struct {
+			Name          string   `json:"name"`
+			FullName      string   `json:"full_name"`
+			HTMLURL       string   `json:"html_url"`
+			Description   string   `json:"description"`
+			Topics        []string `json:"topics"`
+			DefaultBranch string   `json:"default_branch"`
+			Private       bool     `json:"private"`
+			Archived      bool     `json:"archived"`
+			Fork          bool     `json:"fork"`
+			// Internal is set for repos visible to any user of a Gitea
+			// instance.
+			Internal bool `json:"internal"`
+		}
+		if err := json.Unmarshal(data, &results); err != nil {
+			return "", err
+		}
+		for _, r := range results {
+			repos = append(repos, discoveredRepo{
+				Name:          r.Name,
+				FullName:      r.FullName,
+				Subpath:       r.Name,
+				URL:           r.HTMLURL,
+				Description:   r.Description,
+				Topics:        r.Topics,
+				DefaultBranch: r.DefaultBranch,
+				Private:       r.Private || r.Internal,
+				Archived:      r.Archived,
+				Fork:          r.Fork,
+			})
+		}
+		return "", nil
+	}
+	owner := url.PathEscape(c.Gitea)
+	err := getJSONPages(ctx, api+"/orgs/"+owner+"/repos?limit=50", header, page)
+	if err == errAPINotFound {
+		err = getJSONPages(ctx, api+"/users/"+owner+"/repos?limit=50", header, page)
+	}
+	if err == errAPINotFound {
+		err = fmt.Errorf("no Gitea organization or user %q", c.Gitea)
+	}
+	return repos, err
+}
@@ -0,0 +1,320 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxEditBodyBytes caps the size of the requests that change paths.
+const maxEditBodyBytes = 64 << 10
+
+var (
+	// editHandler, if not nil, serves the requests that add, change, and
+	// remove paths.
+	editHandler http.Handler
+	// reloadConfig, if not nil, reloads the config, returning once the
+	// new config is being served.
+	reloadConfig func() error
+	// pathsFile is the file that paths added through the paths API are
+	// kept in. The config file itself is never written.
+	pathsFile string
+	// editMu keeps edits of pathsFile from interleaving.
+	editMu sync.Mutex
+)
+
+var (
+	errPathExists     = errors.New("path already exists")
+	errNoPath         = errors.New("no such path")
+	errNoHost         = errors.New("no such host")
+	errConfiguredPath = errors.New("path is set in the config file")
+	errNoReload       = errors.New("this server can't reload its config")
+)
+
+// managedPaths is the form of pathsFile: the paths of the top-level host
+// under paths:, and those of the other hosts under hosts:, by their
+// lowercased names. Entries are kept as they were given.
+type managedPaths struct {
+	Paths map[string]pathConfig            `yaml:"paths,omitempty"`
+	Hosts map[string]map[string]pathConfig `yaml:"hosts,omitempty"`
+}
+
+// forHost returns the paths of the host called host, creating the map if
+// there is none.
+func (m *managedPaths) forHost(host string) map[string]pathConfig {
+	if strings.EqualFold(host, hosts[0].host) {
+		if m.Paths == nil {
+			m.Paths = make(map[string]pathConfig)
+		}
+		return m.Paths
+	}
+	if m.Hosts == nil {
+		m.Hosts = make(map[string]map[string]pathConfig)
+	}
+	host = strings.ToLower(host)
+	if m.Hosts[host] == nil {
+		m.Hosts[host] = make(map[string]pathConfig)
+	}
+	return m.Hosts[host]
+}
+
+// readManagedPaths reads the paths in file, which need not exist, and
+// returns them along with the contents of the file.
+func readManagedPaths(file string) (*managedPaths, []byte, error) {
+	m := new(managedPaths)
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return m, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, m); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return m, data, nil
+}
+
+// isPathEdit reports whether a request with method for path changes a
+// path through the paths API.
+func isPathEdit(method, path string) bool {
+	switch method {
+	case "POST", "PUT", "DELETE":
+		return strings.HasPrefix(path, apiPathsPrefix+"/")
+	}
+	return false
+}
+
+// servePathEdit serves POST, PUT, and DELETE /api/v1/paths/<path>, which
+// add a path, add or replace it, and remove it. The paths of the host the
+// request was sent to are changed, unless another is named with the host
+// parameter. The new entry is given in the body, as YAML or JSON with the
+// same keys as in the config. The change is written to pathsFile and
+// served at once. Paths set in the config file can't be changed.
+func servePathEdit(w http.ResponseWriter, r *http.Request) {
+	if !isPathEdit(r.Method, r.URL.Path) {
+		w.Header().Set("Allow", "POST, PUT, DELETE")
+		writeJSONError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, apiPathsPrefix)
+	if !validEditPath(path) {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid path "+path)
+		return
+	}
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		if h, _, ok := hostFor(r); ok {
+			host = h.host
+		}
+	}
+	var entry pathConfig
+	if r.Method != "DELETE" {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEditBodyBytes))
+		if err != nil {
+			writeJSONError(w, r, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		entry, err = parsePathEntry(body)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	e := newAuditEvent(r, editActions[r.Method])
+	e.Host, e.Path = host, path
+	err := editPaths(e, r.Method, entry)
+	switch {
+	case err == errPathExists:
+		writeJSONError(w, r, http.StatusConflict, "path "+path+" already exists")
+	case err == errConfiguredPath:
+		writeJSONError(w, r, http.StatusConflict, "path "+path+" is set in the config file")
+	case err == errNoPath:
+		writeJSONError(w, r, http.StatusNotFound, "no such path "+path)
+	case err == errNoHost:
+		writeJSONError(w, r, http.StatusNotFound, "no such host "+host)
+	case err != nil:
+		writeJSONError(w, r, http.StatusUnprocessableEntity, err.Error())
+	case r.Method == "DELETE":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		code := http.StatusOK
+		if r.Method == "POST" {
+			code = http.StatusCreated
+		}
+		writeJSON(w, r, code, struct {
+			Host string `json:"host,omitempty"`
+			Path string `json:"path"`
+		}{host, path})
+	}
+}
+
+// validEditPath reports whether path can be added to the config.
+func validEditPath(path string) bool {
+	return strings.HasPrefix(path, "/") && path != "/" && !strings.HasSuffix(path, "/") && !strings.Contains(path, "//")
+}
+
+// parsePathEntry parses a path entry given as YAML or JSON.
+func parsePathEntry(data []byte) (pathConfig, error) {
+	var entry pathConfig
+	if err := yaml.UnmarshalStrict(data, &entry); err != nil {
+		return pathConfig{}, fmt.Errorf("invalid path entry: %v", err)
+	}
+	if entry.Repo == "" {
+		return pathConfig{}, errors.New("repo is required")
+	}
+	return entry, nil
+}
+
+// editActions names the changes made by each method in the audit log.
+var editActions = map[string]string{
+	"POST":   "add_path",
+	"PUT":    "set_path",
+	"DELETE": "remove_path",
+}
+
+// changePath makes the change to path in paths that method asks for: POST
+// adds entry, PUT adds or replaces it, and DELETE removes the path.
+func changePath(paths map[string]pathConfig, method, path string, entry pathConfig) error {
+	_, ok := paths[path]
+	switch {
+	case method == "POST" && ok:
+		return errPathExists
+	case method == "DELETE" && !ok:
+		return errNoPath
+	case method == "DELETE":
+		delete(paths, path)
+	default:
+		paths[path] = entry
+	}
+	return nil
+}
+
+// editPaths makes the change to e.Path that method asks for, as
+// changePath, in the paths of e.Host kept in pathsFile, and serves the
+// result. Paths set in the config file can't be changed. The attempt is
+// recorded in the audit log as e, whether or not it succeeds.
+func editPaths(e auditEvent, method string, entry pathConfig) (err error) {
+	defer func() {
+		if err != nil {
+			e.Error = err.Error()
+		}
+		recordAudit(e)
+	}()
+	// hosts is set while loading the config, before any edit is served.
+	var h *vanityHost
+	for _, vh := range hosts {
+		if strings.EqualFold(vh.host, e.Host) {
+			h = vh
+			break
+		}
+	}
+	if h == nil {
+		return errNoHost
+	}
+	if _, ok := h.paths[e.Path]; ok {
+		return errConfiguredPath
+	}
+	var resolved pathConfig
+	if method != "DELETE" {
+		// Check the entry before saving it. This can ask the code host
+		// for the repo's default branch, so it is done before taking
+		// editMu, to keep a slow host from holding up other edits.
+		resolved, err = resolveManagedPath(h, e.Path, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	editMu.Lock()
+	defer editMu.Unlock()
+	m, old, err := readManagedPaths(pathsFile)
+	if err != nil {
+		return err
+	}
+	if err := changePath(m.forHost(h.host), method, e.Path, entry); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(pathsFile, data); err != nil {
+		return err
+	}
+	e.Diff = diffLines(string(old), string(data))
+	h.pathsMu.Lock()
+	managed := make(map[string]pathConfig, len(h.managed)+1)
+	for p, me := range h.managed {
+		managed[p] = me
+	}
+	if method == "DELETE" {
+		delete(managed, e.Path)
+	} else {
+		managed[e.Path] = resolved
+	}
+	h.managed = managed
+	h.pathsMu.Unlock()
+	h.mergePaths()
+	return nil
+}
+
+// resolveManagedPath expands and checks the entry e for path on h, and
+// fills in its settings as when the config is loaded.
+func resolveManagedPath(h *vanityHost, path string, e pathConfig) (pathConfig, error) {
+	e = expandPathRepo(e)
+	if e.Branch == "" && detectBranch {
+		e.Branch = codeHosts.DetectDefaultBranches([]string{e.web}, branchCache, slog.Default())[e.web]
+	}
+	return resolvePath(h.host, path, e)
+}
+
+// writeFileAtomic replaces the contents of file with data, so that
+// readers see either the old contents or the new, keeping its
+// permissions. The file is created if it doesn't exist.
+func writeFileAtomic(file string, data []byte) error {
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(file); err == nil {
+		perm = fi.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
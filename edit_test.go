@@ -0,0 +1,206 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupPathEdit serves the paths of a single host, with /configured set in
+// the config, and keeps the paths added through the paths API in a new
+// file, whose name it returns.
+func setupPathEdit(t *testing.T) (*vanityHost, string) {
+	t.Helper()
+	h := setupHost(t, &hostConfig{Paths: map[string]pathConfig{
+		"/configured": {Repo: "https://github.com/rakyll/configured"},
+	}})
+	pathsFile = filepath.Join(t.TempDir(), "vanity.paths.yaml")
+	return h, pathsFile
+}
+
+// editPath sends a request to change path through the paths API and
+// returns the status of the response.
+func editPath(t *testing.T, method, path, body string) int {
+	t.Helper()
+	return sendRequest(servePathEdit, method, apiPathsPrefix+path, nil, body).Code
+}
+
+func TestServePathEdit(t *testing.T) {
+	h, file := setupPathEdit(t)
+
+	if code := editPath(t, "POST", "/portmidi", "repo: https://github.com/rakyll/portmidi\n"); code != http.StatusCreated {
+		t.Fatalf("POST /portmidi = %d; want %d", code, http.StatusCreated)
+	}
+	if p, ok := h.pathMap()["/portmidi"]; !ok || p.Repo != "https://github.com/rakyll/portmidi" || p.VCS != "git" {
+		t.Errorf("after POST, /portmidi = %+v, %t; want the git repo https://github.com/rakyll/portmidi", p, ok)
+	}
+	if code := editPath(t, "POST", "/portmidi", "repo: https://github.com/rakyll/other\n"); code != http.StatusConflict {
+		t.Errorf("POST of an existing path = %d; want %d", code, http.StatusConflict)
+	}
+	if code := editPath(t, "PUT", "/portmidi", `{"repo": "https://github.com/rakyll/portmidi2"}`); code != http.StatusOK {
+		t.Errorf("PUT /portmidi = %d; want %d", code, http.StatusOK)
+	}
+	if p := h.pathMap()["/portmidi"]; p.Repo != "https://github.com/rakyll/portmidi2" {
+		t.Errorf("after PUT, /portmidi repo = %q; want %q", p.Repo, "https://github.com/rakyll/portmidi2")
+	}
+
+	before, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := editPath(t, "PUT", "/configured", "repo: https://github.com/rakyll/other\n"); code != http.StatusConflict {
+		t.Errorf("PUT of a path in the config file = %d; want %d", code, http.StatusConflict)
+	}
+	if code := editPath(t, "DELETE", "/configured", ""); code != http.StatusConflict {
+		t.Errorf("DELETE of a path in the config file = %d; want %d", code, http.StatusConflict)
+	}
+	if p := h.pathMap()["/configured"]; p.Repo != "https://github.com/rakyll/configured" {
+		t.Errorf("/configured repo = %q after trying to change it; want %q", p.Repo, "https://github.com/rakyll/configured")
+	}
+	after, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("refused edits changed %s:\n%s", file, after)
+	}
+
+	if code := editPath(t, "DELETE", "/portmidi", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE /portmidi = %d; want %d", code, http.StatusNoContent)
+	}
+	if _, ok := h.pathMap()["/portmidi"]; ok {
+		t.Error("/portmidi is still served after DELETE")
+	}
+	if code := editPath(t, "DELETE", "/portmidi", ""); code != http.StatusNotFound {
+		t.Errorf("DELETE of a missing path = %d; want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestServePathEditInvalid(t *testing.T) {
+	h, file := setupPathEdit(t)
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"POST", "/", "repo: https://github.com/rakyll/portmidi\n", http.StatusBadRequest},
+		{"POST", "/portmidi/", "repo: https://github.com/rakyll/portmidi\n", http.StatusBadRequest},
+		{"POST", "/portmidi", "vcs: git\n", http.StatusBadRequest},
+		{"POST", "/portmidi", "repo: https://github.com/rakyll/portmidi\nunknown: true\n", http.StatusBadRequest},
+		{"POST", "/portmidi", "repo: https://github.com/rakyll/portmidi\nvcs: cvs\n", http.StatusUnprocessableEntity},
+		{"PATCH", "/portmidi", "repo: https://github.com/rakyll/portmidi\n", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		if code := editPath(t, test.method, test.path, test.body); code != test.want {
+			t.Errorf("%s %s with %q = %d; want %d", test.method, test.path, test.body, code, test.want)
+		}
+	}
+	if _, ok := h.pathMap()["/portmidi"]; ok {
+		t.Error("/portmidi is served after invalid edits")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("invalid edits wrote %s (err = %v)", file, err)
+	}
+}
+
+func TestServePathEditWritesReloadableFile(t *testing.T) {
+	h, file := setupPathEdit(t)
+	// The file's permissions are kept when it is replaced.
+	if err := os.WriteFile(file, []byte("paths:\n  /old:\n    repo: https://github.com/rakyll/old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if code := editPath(t, "POST", "/portmidi", "repo: gh:rakyll/portmidi\nbranch: main\n"); code != http.StatusCreated {
+		t.Fatalf("POST /portmidi = %d; want %d", code, http.StatusCreated)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("%s has permissions %v after the edit; want %v", file, perm, os.FileMode(0600))
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(file), ".*")); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %q", matches)
+	}
+
+	// Read the file back as the config is loaded.
+	m, _, err := readManagedPaths(file)
+	if err != nil {
+		t.Fatalf("readManagedPaths: %v", err)
+	}
+	paths := m.forHost(h.host)
+	if len(paths) != 2 {
+		t.Errorf("%s has paths %v; want /old and /portmidi", file, paths)
+	}
+	for path, e := range paths {
+		if _, err := resolvePath(h.host, path, expandPathRepo(e)); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+	// Entries are kept as they were given, not as they were expanded.
+	if e := paths["/portmidi"]; e.Repo != "gh:rakyll/portmidi" || e.Branch != "main" {
+		t.Errorf("/portmidi in %s = %+v; want repo gh:rakyll/portmidi on branch main", file, e)
+	}
+}
+
+func TestServePathEditAuditsFailures(t *testing.T) {
+	setupPathEdit(t)
+	oldAuditLog := auditLogFile
+	t.Cleanup(func() { auditLogFile = oldAuditLog })
+	auditLogFile = filepath.Join(t.TempDir(), "audit.log")
+
+	editPath(t, "POST", "/portmidi", "repo: https://github.com/rakyll/portmidi\n")
+	editPath(t, "POST", "/portmidi", "repo: https://github.com/rakyll/portmidi\n")
+	editPath(t, "DELETE", "/configured", "")
+	editPath(t, "DELETE", "/missing", "")
+	editPath(t, "POST", "/bad", "repo: https://github.com/rakyll/bad\nvcs: cvs\n")
+
+	data, err := os.ReadFile(auditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []auditEvent
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e auditEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("audit log line %q: %v", line, err)
+		}
+		events = append(events, e)
+	}
+	want := []struct {
+		action, path, err string
+	}{
+		{"add_path", "/portmidi", ""},
+		{"add_path", "/portmidi", errPathExists.Error()},
+		{"remove_path", "/configured", errConfiguredPath.Error()},
+		{"remove_path", "/missing", errNoPath.Error()},
+		{"add_path", "/bad", `unknown VCS "cvs"`},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("audit log has %d events; want %d:\n%s", len(events), len(want), data)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Action != w.action || e.Path != w.path || !strings.Contains(e.Error, w.err) || (w.err == "") != (e.Error == "") {
+			t.Errorf("audit event %d = %s %s, error %q; want %s %s, error %q", i, e.Action, e.Path, e.Error, w.action, w.path, w.err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// export implements the export command, which prints the paths of the
+// config as nginx locations or Caddyfile site blocks, for serving them
+// from a web server that is already running.
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configFile := fs.String("config", "vanity.yaml", "config `file`")
+	format := fs.String("format", "nginx", "`format` to write: nginx or caddy")
+	hostFlag := fs.String("host", "", "host `name` the top-level paths are served on (default from the config)")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: govanityurls export [-config file] [-format nginx|caddy] [-host name]")
+	}
+	var write func(w *bufio.Writer, host string, paths []exportedPath) error
+	switch *format {
+	case "nginx":
+		write = writeNginx
+	case "caddy":
+		write = writeCaddy
+	default:
+		return fmt.Errorf("-format must be nginx or caddy")
+	}
+	named, err := loadStatic(*configFile, *hostFlag)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	for i, n := range named {
+		if i > 0 {
+			w.WriteString("\n")
+		}
+		paths, err := exportPaths(n.vanityHost, n.name)
+		if err != nil {
+			return err
+		}
+		if err := write(w, n.name, paths); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// exportedPath is a path along with the page served for it, which is
+// served for the packages below it too.
+type exportedPath struct {
+	path    string
+	page    string
+	headers map[string]string
+}
+
+// exportPaths renders the page for each path of h, served on host. The
+// paths are sorted longest first, so that nested paths come before those
+// they are nested in.
+func exportPaths(h *vanityHost, host string) ([]exportedPath, error) {
+	var paths []exportedPath
+	for path, e := range h.pathMap() {
+		rec := generateRequest(host, pathPrefix+path)
+		if rec.Code != http.StatusOK {
+			return nil, fmt.Errorf("%s%s: got status %d", host, path, rec.Code)
+		}
+		paths = append(paths, exportedPath{
+			path:    pathPrefix + path,
+			page:    rec.Body.String(),
+			headers: e.Headers,
+		})
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if len(paths[i].path) != len(paths[j].path) {
+			return len(paths[i].path) > len(paths[j].path)
+		}
+		return paths[i].path < paths[j].path
+	})
+	return paths, nil
+}
+
+// writeNginx writes the paths served on host as nginx locations, to be
+// included in the server block for host.
+func writeNginx(w *bufio.Writer, host string, paths []exportedPath) error {
+	fmt.Fprintf(w, "# %s\n", host)
+	for _, p := range paths {
+		// nginx expands variables in the text it returns, with no way to
+		// escape a dollar sign.
+		if strings.Contains(p.page, "$") {
+			return fmt.Errorf("%s%s: page contains a $, which nginx can't return", host, p.path)
+		}
+		page := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p.page)
+		base := strings.TrimSuffix(p.path, "/")
+		for _, loc := range []string{"= " + p.path, "^~ " + base + "/"} {
+			fmt.Fprintf(w, "location %s {\n", loc)
+			for _, k := range sortedKeys(p.headers) {
+				fmt.Fprintf(w, "    add_header %s %s always;\n", k, strconv.Quote(p.headers[k]))
+			}
+			w.WriteString("    default_type \"text/html; charset=utf-8\";\n")
+			fmt.Fprintf(w, "    return 200 '%s';\n}\n", page)
+		}
+	}
+	return nil
+}
+
+// writeCaddy writes the paths served on host as a Caddyfile site block.
+func writeCaddy(w *bufio.Writer, host string, paths []exportedPath) error {
+	fmt.Fprintf(w, "%s {\n", host)
+	for i, p := range paths {
+		// Caddy fills in placeholders, like {file}, in the text it
+		// responds with unless their braces are escaped.
+		page := strings.NewReplacer("{", `\{`, "}", `\}`).Replace(p.page)
+		for _, line := range strings.Split(page, "\n") {
+			if strings.TrimSpace(line) == "HTML" {
+				return fmt.Errorf("%s%s: page contains a line with just HTML, which ends the Caddyfile heredoc", host, p.path)
+			}
+		}
+		base := strings.TrimSuffix(p.path, "/")
+		fmt.Fprintf(w, "\t@path%d path %s %s/*\n", i, p.path, base)
+		fmt.Fprintf(w, "\thandle @path%d {\n", i)
+		for _, k := range sortedKeys(p.headers) {
+			fmt.Fprintf(w, "\t\theader %s %s\n", k, strconv.Quote(p.headers[k]))
+		}
+		w.WriteString("\t\theader Content-Type \"text/html; charset=utf-8\"\n")
+		fmt.Fprintf(w, "\t\trespond <<HTML\n%s\nHTML 200\n\t}\n", page)
+	}
+	w.WriteString("}\n")
+	return nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,29 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import "expvar"
+
+// The counters are published only by the standalone server. On App Engine,
+// importing expvar would serve them on the default mux to anyone.
+func init() {
+	expvar.Publish("requests", expvar.Func(func() interface{} { return stats.requests.Load() }))
+	expvar.Publish("not_found", expvar.Func(func() interface{} { return stats.notFound.Load() }))
+	expvar.Publish("config_loads", expvar.Func(func() interface{} { return stats.configLoads.Load() }))
+	expvar.Publish("config_load_failures", expvar.Func(func() interface{} { return stats.configLoadFailures.Load() }))
+	expvar.Publish("path_hits", expvar.Func(func() interface{} { return pathHits() }))
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies lists the networks of the reverse proxies whose
+// Forwarded, X-Forwarded-Host, and X-Forwarded-Proto headers are believed.
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a list of CIDR blocks or single IP addresses.
+func parseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted_proxies: invalid IP address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_proxies: %v", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy reports whether r came from one of trustedProxies.
+func isTrustedProxy(r *http.Request) bool {
+	if len(trustedProxies) == 0 {
+		return false
+	}
+	addr := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		addr = h
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && isTrustedIP(ip)
+}
+
+// isTrustedIP reports whether ip is in one of trustedProxies.
+func isTrustedIP(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded returns the host and scheme of the original request as
+// reported by a trusted proxy, preferring the RFC 7239 Forwarded header to
+// X-Forwarded-Host and X-Forwarded-Proto. Either is empty if unknown.
+func forwarded(r *http.Request) (host, proto string) {
+	if !isTrustedProxy(r) {
+		return "", ""
+	}
+	if f := r.Header.Get("Forwarded"); f != "" {
+		// Only the first element describes the original request; later
+		// ones are added by each proxy along the way.
+		first := strings.SplitN(f, ",", 2)[0]
+		for _, pair := range strings.Split(first, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v := strings.Trim(kv[1], `"`)
+			switch strings.ToLower(kv[0]) {
+			case "host":
+				host = v
+			case "proto":
+				proto = strings.ToLower(v)
+			}
+		}
+		return host, proto
+	}
+	host = strings.TrimSpace(strings.SplitN(r.Header.Get("X-Forwarded-Host"), ",", 2)[0])
+	proto = strings.ToLower(strings.TrimSpace(strings.SplitN(r.Header.Get("X-Forwarded-Proto"), ",", 2)[0]))
+	return host, proto
+}
+
+// requestHost returns the Host of the original request, which is r.Host
+// unless a trusted proxy says otherwise.
+func requestHost(r *http.Request) string {
+	if host, _ := forwarded(r); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+// clientIP returns the address of the client that sent r, which is its
+// remote address unless trusted proxies forwarded it for someone else.
+func clientIP(r *http.Request) string {
+	addr := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		addr = h
+	}
+	if !isTrustedProxy(r) {
+		return addr
+	}
+	var hops []string
+	if f := r.Header.Get("Forwarded"); f != "" {
+		for _, elem := range strings.Split(f, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					v := strings.Trim(kv[1], `"`)
+					if h, _, err := net.SplitHostPort(v); err == nil {
+						v = h
+					}
+					hops = append(hops, strings.Trim(v, "[]"))
+				}
+			}
+		}
+	} else if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		for _, v := range strings.Split(xff, ",") {
+			hops = append(hops, strings.TrimSpace(v))
+		}
+	}
+	// Each proxy adds the address it got the request from, and anything
+	// before the last proxy we trust could have been made up by the
+	// client, so the client is the last hop that isn't a trusted proxy.
+	for i := len(hops) - 1; i >= 0; i-- {
+		if ip := net.ParseIP(hops[i]); ip == nil || !isTrustedIP(ip) {
+			return hops[i]
+		}
+	}
+	if len(hops) > 0 {
+		return hops[0]
+	}
+	return addr
+}
+
+// requestScheme returns the scheme of the original request. Requests are
+// assumed to have come over HTTPS unless a trusted proxy says otherwise.
+func requestScheme(r *http.Request) string {
+	if _, proto := forwarded(r); proto == "http" || proto == "https" {
+		return proto
+	}
+	return "https"
+}
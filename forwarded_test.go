@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// setupTrustedProxies trusts the proxies in cidrs for the rest of the test.
+func setupTrustedProxies(t *testing.T, cidrs ...string) {
+	t.Helper()
+	nets, err := parseTrustedProxies(cidrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := trustedProxies
+	t.Cleanup(func() { trustedProxies = old })
+	trustedProxies = nets
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		cidrs []string
+		want  []string
+		ok    bool
+	}{
+		{[]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "2001:db8::1"}, []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32", "2001:db8::1/128"}, true},
+		{[]string{}, []string{}, true},
+		{cidrs: []string{"10.0.0.0/33"}},
+		{cidrs: []string{"proxy.example.com"}},
+	}
+	for _, test := range tests {
+		nets, err := parseTrustedProxies(test.cidrs)
+		if (err == nil) != test.ok {
+			t.Errorf("parseTrustedProxies(%q) error = %v; want ok = %t", test.cidrs, err, test.ok)
+			continue
+		}
+		got := make([]string, len(nets))
+		for i, n := range nets {
+			got[i] = n.String()
+		}
+		if test.ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseTrustedProxies(%q) = %q; want %q", test.cidrs, got, test.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	setupTrustedProxies(t, "10.0.0.0/8", "2001:db8::/32")
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		xff       string
+		want      string
+	}{
+		{name: "direct", remote: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "direct IPv6", remote: "[2001:db9::1]:1234", want: "2001:db9::1"},
+		{name: "untrusted peer spoofing X-Forwarded-For", remote: "192.0.2.1:1234", xff: "198.51.100.7", want: "192.0.2.1"},
+		{name: "untrusted peer spoofing Forwarded", remote: "192.0.2.1:1234", forwarded: "for=198.51.100.7", want: "192.0.2.1"},
+		{name: "one trusted proxy", remote: "10.0.0.1:1234", xff: "192.0.2.1", want: "192.0.2.1"},
+		{name: "trusted proxy chain", remote: "10.0.0.1:1234", xff: "192.0.2.1, 10.0.0.2, 10.0.0.3", want: "192.0.2.1"},
+		{name: "client-supplied hop before trusted chain", remote: "10.0.0.1:1234", xff: "198.51.100.7, 192.0.2.1, 10.0.0.2", want: "192.0.2.1"},
+		{name: "untrusted proxy in chain", remote: "10.0.0.1:1234", xff: "192.0.2.1, 203.0.113.5, 10.0.0.2", want: "203.0.113.5"},
+		{name: "garbage hop", remote: "10.0.0.1:1234", xff: "192.0.2.1, unknown", want: "unknown"},
+		{name: "only trusted hops", remote: "10.0.0.1:1234", xff: "10.0.0.2, 10.0.0.3", want: "10.0.0.2"},
+		{name: "trusted proxy without headers", remote: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "Forwarded", remote: "10.0.0.1:1234", forwarded: "for=192.0.2.1;proto=https", want: "192.0.2.1"},
+		{name: "Forwarded chain", remote: "10.0.0.1:1234", forwarded: "for=198.51.100.7, for=192.0.2.1, for=10.0.0.2", want: "192.0.2.1"},
+		{name: "Forwarded quoted IPv6 with port", remote: "[2001:db8::2]:1234", forwarded: `for="[2001:db9::1]:4711", for="[2001:db8::3]"`, want: "2001:db9::1"},
+		{name: "Forwarded with port", remote: "10.0.0.1:1234", forwarded: `For="192.0.2.1:4711"`, want: "192.0.2.1"},
+		{name: "Forwarded preferred", remote: "10.0.0.1:1234", forwarded: "for=192.0.2.1", xff: "198.51.100.7", want: "192.0.2.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://go.example.com/portmidi", nil)
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("Forwarded", test.forwarded)
+		}
+		if test.xff != "" {
+			r.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if got := clientIP(r); got != test.want {
+			t.Errorf("%s: clientIP(RemoteAddr %q, Forwarded %q, X-Forwarded-For %q) = %q; want %q", test.name, test.remote, test.forwarded, test.xff, got, test.want)
+		}
+	}
+}
+
+func TestRequestHostAndScheme(t *testing.T) {
+	setupTrustedProxies(t, "10.0.0.0/8")
+	tests := []struct {
+		name   string
+		remote string
+		header map[string]string
+		host   string
+		scheme string
+	}{
+		{name: "direct", remote: "192.0.2.1:1234", host: "go.example.com", scheme: "https"},
+		{
+			name:   "untrusted peer spoofing Forwarded",
+			remote: "192.0.2.1:1234",
+			header: map[string]string{"Forwarded": "host=evil.example.com;proto=http"},
+			host:   "go.example.com",
+			scheme: "https",
+		},
+		{
+			name:   "untrusted peer spoofing X-Forwarded-Host",
+			remote: "192.0.2.1:1234",
+			header: map[string]string{"X-Forwarded-Host": "evil.example.com", "X-Forwarded-Proto": "http"},
+			host:   "go.example.com",
+			scheme: "https",
+		},
+		{
+			name:   "X-Forwarded-Host",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{"X-Forwarded-Host": "vanity.example.com, proxy.internal", "X-Forwarded-Proto": "HTTP, https"},
+			host:   "vanity.example.com",
+			scheme: "http",
+		},
+		{
+			name:   "Forwarded",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{"Forwarded": "for=192.0.2.1;host=vanity.example.com;proto=http"},
+			host:   "vanity.example.com",
+			scheme: "http",
+		},
+		{
+			name:   "Forwarded quoted host",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{"Forwarded": `host="vanity.example.com:8443"; Proto=HTTPS`},
+			host:   "vanity.example.com:8443",
+			scheme: "https",
+		},
+		{
+			name:   "Forwarded chain uses first element",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{"Forwarded": "host=vanity.example.com;proto=http, host=proxy.internal;proto=https"},
+			host:   "vanity.example.com",
+			scheme: "http",
+		},
+		{
+			name:   "Forwarded preferred",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{"Forwarded": "host=vanity.example.com", "X-Forwarded-Host": "other.example.com", "X-Forwarded-Proto": "http"},
+			host:   "vanity.example.com",
+			scheme: "https",
+		},
+		{
+			name:   "unknown scheme",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{"X-Forwarded-Proto": "gopher"},
+			host:   "go.example.com",
+			scheme: "https",
+		},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://go.example.com/portmidi", nil)
+		r.RemoteAddr = test.remote
+		for k, v := range test.header {
+			r.Header.Set(k, v)
+		}
+		if got := requestHost(r); got != test.host {
+			t.Errorf("%s: requestHost(RemoteAddr %q, %q) = %q; want %q", test.name, test.remote, test.header, got, test.host)
+		}
+		if got := requestScheme(r); got != test.scheme {
+			t.Errorf("%s: requestScheme(RemoteAddr %q, %q) = %q; want %q", test.name, test.remote, test.header, got, test.scheme)
+		}
+	}
+}
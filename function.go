@@ -0,0 +1,124 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cloudfunctions
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// init loads the config for the VanityURLs function. It is given as YAML
+// in $VANITY_CONFIG_YAML, or read from the file named by $VANITY_CONFIG,
+// which may be a gs://bucket/object URL, or else from vanity.yaml
+// alongside the function.
+func init() {
+	file, err := functionConfig(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	loadConfig(file)
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+	logConfig()
+	isReady.Store(true)
+	functions.HTTP("VanityURLs", VanityURLs)
+}
+
+// VanityURLs is the entry point of the app as a Google Cloud Function.
+func VanityURLs(w http.ResponseWriter, r *http.Request) {
+	handle(w, r)
+}
+
+// main serves the function with the Functions Framework, for deploying
+// it to Cloud Run from source or running it locally.
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	if err := funcframework.Start(port); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// functionConfig returns the name of the file to load the config from,
+// copying it from $VANITY_CONFIG_YAML or Cloud Storage to a temporary file
+// if need be.
+func functionConfig(ctx context.Context) (string, error) {
+	if data := os.Getenv("VANITY_CONFIG_YAML"); data != "" {
+		return writeTempConfig(strings.NewReader(data))
+	}
+	file := os.Getenv("VANITY_CONFIG")
+	if file == "" {
+		return "vanity.yaml", nil
+	}
+	if !strings.HasPrefix(file, "gs://") {
+		return file, nil
+	}
+	u, err := url.Parse(file)
+	if err != nil {
+		return "", fmt.Errorf("VANITY_CONFIG: %v", err)
+	}
+	bucket, object := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || object == "" {
+		return "", fmt.Errorf("VANITY_CONFIG %q is not a gs://bucket/object URL", file)
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	rd, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get %s: %v", file, err)
+	}
+	defer rd.Close()
+	return writeTempConfig(rd)
+}
+
+// writeTempConfig copies the config from r to a temporary file and
+// returns its name.
+func writeTempConfig(r io.Reader) (string, error) {
+	file := filepath.Join(os.TempDir(), "vanity.yaml")
+	f, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return "", err
+	}
+	return file, f.Close()
+}
+
+// defaultHost returns the host that a trusted proxy forwarded r for, or
+// else the host r was sent to.
+func defaultHost(r *http.Request) string {
+	return requestHost(r)
+}
@@ -0,0 +1,187 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// generate implements the generate command, which renders the pages
+// served for the config to a directory of static files that any web
+// server can serve.
+func generate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	configFile := fs.String("config", "vanity.yaml", "config `file`")
+	outDir := fs.String("o", "", "`directory` to write the site to")
+	hostFlag := fs.String("host", "", "host `name` the top-level paths are served on (default from the config)")
+	fs.Parse(args)
+	if *outDir == "" || fs.NArg() > 0 {
+		return fmt.Errorf("usage: govanityurls generate [-config file] [-host name] -o directory")
+	}
+	named, err := loadStatic(*configFile, *hostFlag)
+	if err != nil {
+		return err
+	}
+	for _, n := range named {
+		dir := *outDir
+		if len(hosts) > 1 {
+			dir = filepath.Join(dir, n.name)
+		}
+		if err := generateHost(dir, n.vanityHost, n.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namedHost is a configured host along with the name it is served on.
+type namedHost struct {
+	*vanityHost
+	name string
+}
+
+// loadStatic loads the config in file for rendering pages outside of a
+// running server, and returns the hosts with paths to render. hostFlag
+// names the top-level host if the config doesn't.
+func loadStatic(file, hostFlag string) ([]namedHost, error) {
+	loadConfig(file)
+	if err := setupLogging(); err != nil {
+		return nil, err
+	}
+	// Every page is requested from the same address.
+	limiter = nil
+	var named []namedHost
+	for i, h := range hosts {
+		name := h.host
+		if name == "" {
+			name = hostFlag
+		}
+		if name == "" {
+			if i == 0 && len(h.pathMap()) == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("the top-level paths have no host; set host: in the config or -host")
+		}
+		h.served.Store(metaRedirectPaths(h, name))
+		named = append(named, namedHost{h, name})
+	}
+	return named, nil
+}
+
+// metaRedirectPaths returns the paths of h, served on host, changed to
+// redirect browsers with a meta tag, as only a running server can redirect
+// them over HTTP.
+func metaRedirectPaths(h *vanityHost, host string) *map[string]pathConfig {
+	paths := make(map[string]pathConfig)
+	for path, e := range h.pathMap() {
+		if e.Proxy {
+			slog.Warn("module proxy not supported", "path", host+path)
+		}
+		if e.RedirectMode == "http" {
+			slog.Warn("redirecting with a meta tag instead of over HTTP", "path", host+path)
+			e.RedirectMode = "meta"
+		}
+		paths[path] = e
+	}
+	return &paths
+}
+
+// generateHost writes the pages of h, served on host, to dir: a page for
+// each path and each of its packages, the index, and a 404.html page, which
+// static hosts like GitHub Pages serve for anything else.
+func generateHost(dir string, h *vanityHost, host string) error {
+	paths := h.pathMap()
+	// The whole index goes on one page, as there's no one to answer for
+	// the others.
+	h.indexPageSize = max(len(paths), 1)
+	files := map[string]string{
+		"/index.json":  "/index.json",
+		"/robots.txt":  "/robots.txt",
+		"/sitemap.xml": "/sitemap.xml",
+		"/favicon.ico": "/favicon.ico",
+		"/":            "/index.html",
+	}
+	for path, e := range paths {
+		base := strings.TrimSuffix(path, "/")
+		files[path] = base + "/index.html"
+		for _, pkg := range e.Packages {
+			files[base+"/"+pkg] = base + "/" + pkg + "/index.html"
+		}
+	}
+	pages := make([]string, 0, len(files))
+	for page := range files {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+	for _, page := range pages {
+		rec := generateRequest(host, pathPrefix+page)
+		if rec.Code == http.StatusFound {
+			rec = generateRedirect(rec.Header().Get("Location"))
+		}
+		if rec.Code != http.StatusOK {
+			return fmt.Errorf("%s%s: got status %d", host, page, rec.Code)
+		}
+		if err := writeGenerated(dir, pathPrefix+files[page], rec.Body.Bytes()); err != nil {
+			return err
+		}
+	}
+	notFound := []byte("404 page not found\n")
+	if h.notFoundTmpl != nil {
+		rec := generateRequest(host, pathPrefix+"/404.html")
+		if rec.Code != http.StatusNotFound {
+			return fmt.Errorf("%s/404.html: got status %d", host, rec.Code)
+		}
+		notFound = rec.Body.Bytes()
+	}
+	return writeGenerated(dir, pathPrefix+"/404.html", notFound)
+}
+
+// generateRequest returns the response to a GET request for path on host.
+func generateRequest(host, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	r.Host = host
+	rec := httptest.NewRecorder()
+	handle(rec, r)
+	return rec
+}
+
+// generateRedirect returns a page that sends browsers to url, for
+// redirects that a static site can't make.
+func generateRedirect(url string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	fmt.Fprintf(rec, "<!DOCTYPE html>\n<html>\n<head>\n<meta http-equiv=\"refresh\" content=\"0; url=%s\">\n</head>\n</html>\n", html.EscapeString(url))
+	return rec
+}
+
+// writeGenerated writes data to the file name, a slash-separated path,
+// under dir.
+func writeGenerated(dir, name string, data []byte) error {
+	file := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(name, "/")))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0o644)
+}
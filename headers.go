@@ -0,0 +1,88 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultHSTS = "max-age=31536000"
+	defaultCSP  = "default-src 'none'; img-src 'self'; style-src 'self'; font-src 'self'; frame-ancestors 'none'"
+	// markupCSP is the default Content-Security-Policy when the config
+	// brings markup of its own, like custom templates or an analytics
+	// snippet, whose inline scripts and styles and resources on other
+	// sites defaultCSP would block.
+	markupCSP = "default-src 'self' https: data: 'unsafe-inline'; frame-ancestors 'none'"
+)
+
+// Values of the Strict-Transport-Security and Content-Security-Policy
+// headers. An empty string omits the header.
+var (
+	hsts = defaultHSTS
+	csp  = defaultCSP
+)
+
+// setSecurityHeaders adds the security headers that accompany every
+// response, along with any configured extra headers.
+func setSecurityHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	if hsts != "" {
+		h.Set("Strict-Transport-Security", hsts)
+	}
+	if csp != "" {
+		h.Set("Content-Security-Policy", csp)
+	}
+	setHeaders(w, extraHeaders)
+}
+
+// hasCustomMarkup reports whether any of configs has templates or an
+// analytics snippet of its own.
+func hasCustomMarkup(configs ...*hostConfig) bool {
+	for _, c := range configs {
+		if c.VanityTemplate != "" || c.IndexTemplate != "" || c.NotFoundTemplate != "" || c.Analytics != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// extraHeaders are the configured headers added to every response.
+var extraHeaders map[string]string
+
+// checkHeaders reports an error if headers contains a header that can't be
+// sent as given.
+func checkHeaders(headers map[string]string) error {
+	for k, v := range headers {
+		if k == "" || strings.ContainsAny(k, " \t\r\n:") {
+			return fmt.Errorf("invalid header name %q", k)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("header %s: value contains a newline", k)
+		}
+	}
+	return nil
+}
+
+// setHeaders sets each of headers on w, replacing any values already set.
+func setHeaders(w http.ResponseWriter, headers map[string]string) {
+	h := w.Header()
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	// healthPath answers as long as the process is alive.
+	healthPath = "/healthz"
+	// readyPath answers successfully once the config is loaded and the
+	// server is listening, until it starts shutting down.
+	readyPath = "/readyz"
+)
+
+// isReady is set while the server should be sent traffic.
+var isReady atomic.Bool
+
+// isHealthPath reports whether path is one of the health check endpoints,
+// which are answered for any host, ahead of the configured paths.
+func isHealthPath(path string) bool {
+	return path == healthPath || path == readyPath
+}
+
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Path == readyPath && !isReady.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,308 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	texttemplate "text/template"
+
+	"github.com/GoogleCloudPlatform/govanityurls/internal/vanitypage"
+)
+
+// hostConfig is the part of the config that can be given separately for
+// each host.
+type hostConfig struct {
+	Host                string                `yaml:"host,omitempty"`
+	DocsURL             string                `yaml:"docs_url,omitempty"`
+	VanityTemplate      string                `yaml:"vanity_template,omitempty"`
+	IndexTemplate       string                `yaml:"index_template,omitempty"`
+	NotFoundTemplate    string                `yaml:"not_found_template,omitempty"`
+	Index               *bool                 `yaml:"index,omitempty"`
+	IndexRedirect       string                `yaml:"index_redirect,omitempty"`
+	Upstream            string                `yaml:"upstream,omitempty"`
+	IndexGroupBy        string                `yaml:"index_group_by,omitempty"`
+	IndexSort           string                `yaml:"index_sort,omitempty"`
+	IndexPageSize       int                   `yaml:"index_page_size,omitempty"`
+	Analytics           string                `yaml:"analytics,omitempty"`
+	InstallInstructions *bool                 `yaml:"install_instructions,omitempty"`
+	Paths               map[string]pathConfig `yaml:"paths,omitempty"`
+	Discover            []discoverConfig      `yaml:"discover,omitempty"`
+}
+
+// inherit fills in the settings of c that were left unset from defaults.
+// Paths and discovery are never inherited.
+func (c *hostConfig) inherit(defaults *hostConfig) {
+	if c.DocsURL == "" {
+		c.DocsURL = defaults.DocsURL
+	}
+	if c.VanityTemplate == "" {
+		c.VanityTemplate = defaults.VanityTemplate
+	}
+	if c.IndexTemplate == "" {
+		c.IndexTemplate = defaults.IndexTemplate
+	}
+	if c.NotFoundTemplate == "" {
+		c.NotFoundTemplate = defaults.NotFoundTemplate
+	}
+	if c.Index == nil {
+		c.Index = defaults.Index
+	}
+	if c.IndexRedirect == "" {
+		c.IndexRedirect = defaults.IndexRedirect
+	}
+	if c.Upstream == "" {
+		c.Upstream = defaults.Upstream
+	}
+	if c.IndexGroupBy == "" {
+		c.IndexGroupBy = defaults.IndexGroupBy
+	}
+	if c.IndexSort == "" {
+		c.IndexSort = defaults.IndexSort
+	}
+	if c.IndexPageSize == 0 {
+		c.IndexPageSize = defaults.IndexPageSize
+	}
+	if c.Analytics == "" {
+		c.Analytics = defaults.Analytics
+	}
+	if c.InstallInstructions == nil {
+		c.InstallInstructions = defaults.InstallInstructions
+	}
+}
+
+// vanityHost is the set of paths served on a single host, along with the
+// templates used to render them.
+type vanityHost struct {
+	// host is the host name the paths are served on. If empty, the paths
+	// are served on the default host.
+	host  string
+	paths map[string]pathConfig
+
+	// managed holds the paths added through the paths API, which are kept
+	// in pathsFile rather than the config file.
+	managed map[string]pathConfig
+
+	// discover lists the places more paths are discovered, and served
+	// holds the paths served on the host: the configured paths along with
+	// those added through the paths API and those discovered. pathsMu
+	// guards managed and the paths of the sources.
+	discover []*discoverSource
+	served   atomic.Pointer[map[string]pathConfig]
+	pathsMu  sync.Mutex
+	// cancelDiscovery stops the background listing of the sources.
+	cancelDiscovery context.CancelFunc
+
+	// docsURL builds the URL of a package's documentation from its import
+	// path.
+	docsURL      *texttemplate.Template
+	vanityTmpl   *template.Template
+	indexTmpl    *template.Template
+	notFoundTmpl *template.Template
+
+	// indexDisabled is set when the index page is turned off, so as not to
+	// reveal the configured paths.
+	indexDisabled bool
+	// indexRedirect is where to send visitors to the index, if anywhere.
+	indexRedirect string
+	// indexGroupByPrefix is set to group paths without a group on the
+	// index page by their first element.
+	indexGroupByPrefix bool
+	// indexSortRecent is set to list the most recently added paths first.
+	indexSortRecent bool
+	// indexPageSize is the number of paths on each page of the index.
+	indexPageSize int
+
+	// installInstructions is set to show browsers how to install a path's
+	// packages instead of redirecting them.
+	installInstructions bool
+
+	// analytics is an HTML snippet added to pages for browsers, but not
+	// to responses for the go command.
+	analytics template.HTML
+
+	// upstream, if not nil, serves requests that match no path.
+	upstream http.Handler
+}
+
+// pathMap returns the paths served on h. The map must not be modified.
+func (h *vanityHost) pathMap() map[string]pathConfig {
+	if m := h.served.Load(); m != nil {
+		return *m
+	}
+	return h.paths
+}
+
+// newUpstream returns a reverse proxy to the server at rawURL. The request
+// is sent with the upstream's host name, and the original host is passed
+// along in X-Forwarded-Host.
+func newUpstream(rawURL string) (http.Handler, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("upstream: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("upstream %q is not an HTTP URL", rawURL)
+	}
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(u)
+			pr.SetXForwarded()
+		},
+	}, nil
+}
+
+// hosts lists the configured hosts. The first is the host described by
+// the top level of the config, which serves requests for any host not
+// listed.
+var hosts []*vanityHost
+
+func newVanityHost(c *hostConfig) (*vanityHost, error) {
+	h := &vanityHost{
+		host:          c.Host,
+		paths:         c.Paths,
+		vanityTmpl:    vanitypage.Template,
+		indexTmpl:     indexTmpl,
+		indexDisabled: c.Index != nil && !*c.Index,
+		indexRedirect: c.IndexRedirect,
+		indexPageSize: c.IndexPageSize,
+		analytics:     template.HTML(c.Analytics),
+	}
+	h.installInstructions = c.InstallInstructions != nil && *c.InstallInstructions
+	if h.paths == nil {
+		h.paths = make(map[string]pathConfig)
+	}
+	h.managed = make(map[string]pathConfig)
+	switch c.IndexGroupBy {
+	case "", "group":
+	case "prefix":
+		h.indexGroupByPrefix = true
+	default:
+		return nil, fmt.Errorf("index_group_by must be group or prefix")
+	}
+	switch {
+	case h.indexPageSize == 0:
+		h.indexPageSize = defaultIndexPageSize
+	case h.indexPageSize < 0:
+		return nil, fmt.Errorf("index_page_size must be positive")
+	}
+	switch c.IndexSort {
+	case "", "name":
+	case "recent":
+		h.indexSortRecent = true
+	default:
+		return nil, fmt.Errorf("index_sort must be name or recent")
+	}
+	docsURL := c.DocsURL
+	if docsURL == "" {
+		docsURL = "https://pkg.go.dev/{{.Import}}"
+	}
+	var err error
+	h.docsURL, err = texttemplate.New("docs_url").Parse(docsURL)
+	if err != nil {
+		return nil, err
+	}
+	if c.VanityTemplate != "" {
+		h.vanityTmpl, err = template.ParseFiles(c.VanityTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.IndexTemplate != "" {
+		h.indexTmpl, err = template.ParseFiles(c.IndexTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.NotFoundTemplate != "" {
+		h.notFoundTmpl, err = template.ParseFiles(c.NotFoundTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.Upstream != "" {
+		h.upstream, err = newUpstream(c.Upstream)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, dc := range c.Discover {
+		s, err := newDiscoverSource(dc)
+		if err != nil {
+			return nil, err
+		}
+		h.discover = append(h.discover, s)
+	}
+	return h, nil
+}
+
+var (
+	// strictHost is set to reject requests for unknown hosts with 421
+	// Misdirected Request rather than serving the top-level paths.
+	strictHost bool
+	// allowedHosts lists the host names accepted in strict-host mode in
+	// addition to the configured hosts.
+	allowedHosts []string
+)
+
+// hostFor returns the configured host that serves r, along with the host
+// name that import paths are built from. It reports false if r is for an
+// unknown host in strict-host mode.
+func hostFor(r *http.Request) (*vanityHost, string, bool) {
+	for _, h := range hosts[1:] {
+		if matchHost(h.host, r) {
+			return h, h.host, true
+		}
+	}
+	h := hosts[0]
+	if strictHost && !matchHost(h.host, r) && !isAllowedHost(r) {
+		return nil, "", false
+	}
+	if h.host == "" {
+		return h, defaultHost(r), true
+	}
+	return h, h.host, true
+}
+
+// matchHost reports whether r is for host, with or without a port.
+func matchHost(host string, r *http.Request) bool {
+	if host == "" {
+		return false
+	}
+	reqHost := requestHost(r)
+	hostname := reqHost
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = h
+	}
+	return strings.EqualFold(host, reqHost) || strings.EqualFold(host, hostname)
+}
+
+// isAllowedHost reports whether r is for one of allowedHosts.
+func isAllowedHost(r *http.Request) bool {
+	for _, host := range allowedHosts {
+		if matchHost(host, r) {
+			return true
+		}
+	}
+	return false
+}
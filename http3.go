@@ -0,0 +1,45 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns an HTTP/3 server for the UDP address addr, using
+// the same certificates as the TLS listeners.
+func newHTTP3Server(addr string, tlsConfig *tls.Config, h http.Handler) *http3.Server {
+	return &http3.Server{
+		Addr:      addr,
+		Handler:   h,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+	}
+}
+
+// advertiseHTTP3 wraps h to add an Alt-Svc header to responses over TLS,
+// telling clients that h3 can be reached over HTTP/3.
+func advertiseHTTP3(h3 *http3.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			h3.SetQUICHeaders(w.Header())
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,258 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// importClient fetches pages from the vanity host being imported.
+var importClient = &http.Client{Timeout: 30 * time.Second}
+
+// importHost implements the import command, which crawls the go-import
+// meta tags of an existing vanity host and prints an equivalent config.
+func importHost(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	pathsFile := fs.String("paths", "", "`file` listing the paths to import, one per line (default from the host's index page)")
+	hostFlag := fs.String("host", "", "host `name` of the import paths (default from the URL)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: govanityurls import [-paths file] https://old.example.com")
+	}
+	base, err := url.Parse(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return fmt.Errorf("%s is not an HTTP URL", fs.Arg(0))
+	}
+	host := base.Host
+	if *hostFlag != "" {
+		host = *hostFlag
+	}
+	var candidates []string
+	details := make(map[string]indexEntry)
+	if *pathsFile != "" {
+		candidates, err = readPathList(*pathsFile)
+	} else {
+		candidates, details, err = crawlIndex(base, host)
+	}
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]pathConfig)
+	for _, candidate := range candidates {
+		imp := host + candidate
+		prefix, e, err := fetchGoImport(base, candidate, imp)
+		if err != nil {
+			slog.Warn("skipping path", "path", imp, "err", err)
+			continue
+		}
+		if !strings.HasPrefix(prefix, host+"/") {
+			slog.Warn("skipping path served for another host", "path", imp, "import", prefix)
+			continue
+		}
+		path := strings.TrimPrefix(prefix, host)
+		if _, ok := paths[path]; ok {
+			continue
+		}
+		if d, ok := details[path]; ok {
+			e.Description, e.Group, e.Added = d.Description, d.Group, d.Added
+		}
+		paths[path] = e
+	}
+	out, err := yaml.Marshal(struct {
+		Host  string                `yaml:"host"`
+		Paths map[string]pathConfig `yaml:"paths"`
+	}{host, paths})
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// readPathList reads the paths listed in file, one per line.
+func readPathList(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var paths []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, "/"+strings.Trim(line, "/"))
+	}
+	return paths, s.Err()
+}
+
+// crawlIndex returns the paths on host linked from the index page at
+// base. If the host serves index.json, as this app does, the paths are
+// taken from it, along with their details.
+func crawlIndex(base *url.URL, host string) ([]string, map[string]indexEntry, error) {
+	var (
+		paths   []string
+		details = make(map[string]indexEntry)
+	)
+	for page := 1; ; page++ {
+		var index struct {
+			Pages int          `json:"pages"`
+			Paths []indexEntry `json:"paths"`
+		}
+		u := base.ResolveReference(&url.URL{Path: "/index.json", RawQuery: fmt.Sprintf("page=%d", page)})
+		if err := getImportJSON(u.String(), &index); err != nil {
+			break
+		}
+		for _, e := range index.Paths {
+			paths = append(paths, e.Path)
+			details[e.Path] = e
+		}
+		if page >= index.Pages {
+			return paths, details, nil
+		}
+	}
+	resp, err := importClient.Get(base.ResolveReference(&url.URL{Path: "/"}).String())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: %s", resp.Request.URL, resp.Status)
+	}
+	seen := make(map[string]bool)
+	d := xml.NewDecoder(resp.Body)
+	d.Strict, d.AutoClose, d.Entity = false, xml.HTMLAutoClose, xml.HTMLEntity
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		start, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(start.Name.Local, "a") {
+			continue
+		}
+		path := linkedPath(base, host, attrValue(start.Attr, "href"))
+		if path != "" && path != "/" && !strings.HasPrefix(path, "/-/") && !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no paths are linked from %s; list them with -paths", base)
+	}
+	return paths, details, nil
+}
+
+// linkedPath returns the path on host that href, a link on the page at
+// base, is for: either a link to the path on host itself or one that
+// includes the import path, like a link to its documentation.
+func linkedPath(base *url.URL, host, href string) string {
+	u, err := base.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if strings.EqualFold(u.Host, host) || u.Host == base.Host {
+		return strings.TrimSuffix(u.Path, "/")
+	}
+	if i := strings.Index(u.Path, "/"+host+"/"); i >= 0 {
+		return strings.TrimSuffix(u.Path[i+len(host)+1:], "/")
+	}
+	return ""
+}
+
+// fetchGoImport fetches the page the go command gets for imp, at path
+// under base, and returns the import prefix of its go-import meta tag
+// along with the path that serves it.
+func fetchGoImport(base *url.URL, path, imp string) (string, pathConfig, error) {
+	u := base.ResolveReference(&url.URL{Path: path, RawQuery: "go-get=1"})
+	resp, err := importClient.Get(u.String())
+	if err != nil {
+		return "", pathConfig{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", pathConfig{}, err
+	}
+	var goImport, goSource []string
+	for _, content := range metaContents(bytes.NewReader(body), "go-import") {
+		// The go command uses the tag whose prefix imp is under.
+		fields := strings.Fields(content)
+		if len(fields) == 3 && (imp == fields[0] || strings.HasPrefix(imp, fields[0]+"/")) {
+			goImport = fields
+		}
+	}
+	for _, content := range metaContents(bytes.NewReader(body), "go-source") {
+		if fields := strings.Fields(content); len(fields) == 4 {
+			goSource = fields
+		}
+	}
+	if goImport == nil {
+		return "", pathConfig{}, fmt.Errorf("%s: no go-import meta tag (%s)", u, resp.Status)
+	}
+	prefix := goImport[0]
+	e := pathConfig{Repo: goImport[2], VCS: goImport[1]}
+	if goSource != nil && goSource[0] == prefix {
+		e.Display = strings.Join(goSource[1:], " ")
+	}
+	// Leave out what this app would fill in by itself, given the default
+	// branch of the config printed.
+	if def, err := resolvePath("", prefix, expandPathRepo(pathConfig{Repo: e.Repo, Branch: "master"})); err == nil {
+		if e.VCS == def.VCS {
+			e.VCS = ""
+		}
+		if e.Display == def.Display {
+			e.Display = ""
+		}
+	}
+	return prefix, e, nil
+}
+
+// getImportJSON fetches the JSON document at url into v.
+func getImportJSON(url string, v interface{}) error {
+	resp, err := importClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,242 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexEntry describes a single path on the index page.
+type indexEntry struct {
+	Path        string            `json:"path"`
+	Import      string            `json:"import"`
+	VCS         string            `json:"vcs"`
+	Repo        string            `json:"repo"`
+	Description string            `json:"description,omitempty"`
+	DocsURL     string            `json:"docs_url"`
+	Group       string            `json:"group,omitempty"`
+	Added       string            `json:"added,omitempty"`
+	Data        map[string]string `json:"data,omitempty"`
+
+	added time.Time
+}
+
+// indexGroup is a list of paths under a single heading on the index page.
+type indexGroup struct {
+	Name  string
+	Paths []indexEntry
+}
+
+// indexEntries returns the configured paths on host, sorted by path or,
+// if so configured, with the most recently added first.
+func (h *vanityHost) indexEntries(host string) []indexEntry {
+	all := h.pathMap()
+	entries := make([]indexEntry, 0, len(all))
+	for path, p := range all {
+		group := p.Group
+		if group == "" && h.indexGroupByPrefix {
+			if i := strings.Index(path[1:], "/"); i >= 0 {
+				group = path[1 : i+1]
+			}
+		}
+		entries = append(entries, indexEntry{
+			Path:        path,
+			Import:      host + path,
+			VCS:         p.VCS,
+			Repo:        p.web,
+			Description: p.Description,
+			DocsURL:     h.docsURLFor(host + path),
+			Group:       group,
+			Added:       p.Added,
+			Data:        p.Data,
+			added:       p.added,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if h.indexSortRecent && !entries[i].added.Equal(entries[j].added) {
+			return entries[i].added.After(entries[j].added)
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+// indexGroups splits entries into groups, keeping their order within each
+// group. Paths without a group come first, followed by the groups in
+// order of name.
+func indexGroups(entries []indexEntry) []indexGroup {
+	byName := make(map[string][]indexEntry)
+	for _, e := range entries {
+		byName[e.Group] = append(byName[e.Group], e)
+	}
+	groups := make([]indexGroup, 0, len(byName))
+	for name, paths := range byName {
+		groups = append(groups, indexGroup{Name: name, Paths: paths})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// defaultIndexPageSize is the number of paths on each page of the index,
+// unless configured otherwise.
+const defaultIndexPageSize = 100
+
+// indexPage is a single page of the index, after filtering by a search
+// query.
+type indexPage struct {
+	Query   string
+	Page    int
+	Pages   int
+	Total   int
+	Paths   []indexEntry
+	PrevURL string
+	NextURL string
+}
+
+// indexPage returns the page of the index of host selected by the q and
+// page query parameters.
+func (h *vanityHost) indexPage(host string, query url.Values) indexPage {
+	p := indexPage{Query: strings.TrimSpace(query.Get("q"))}
+	entries := h.indexEntries(host)
+	if p.Query != "" {
+		q := strings.ToLower(p.Query)
+		matches := entries[:0]
+		for _, e := range entries {
+			if strings.Contains(strings.ToLower(e.Import+" "+e.Description+" "+e.Group), q) {
+				matches = append(matches, e)
+			}
+		}
+		entries = matches
+	}
+	p.Total = len(entries)
+	p.Pages = (p.Total + h.indexPageSize - 1) / h.indexPageSize
+	if p.Pages == 0 {
+		p.Pages = 1
+	}
+	p.Page, _ = strconv.Atoi(query.Get("page"))
+	if p.Page < 1 {
+		p.Page = 1
+	} else if p.Page > p.Pages {
+		p.Page = p.Pages
+	}
+	start := (p.Page - 1) * h.indexPageSize
+	end := start + h.indexPageSize
+	if end > p.Total {
+		end = p.Total
+	}
+	p.Paths = entries[start:end]
+	pageURL := func(page int) string {
+		v := make(url.Values)
+		if p.Query != "" {
+			v.Set("q", p.Query)
+		}
+		if page > 1 {
+			v.Set("page", strconv.Itoa(page))
+		}
+		return "?" + v.Encode()
+	}
+	if p.Page > 1 {
+		p.PrevURL = pageURL(p.Page - 1)
+	}
+	if p.Page < p.Pages {
+		p.NextURL = pageURL(p.Page + 1)
+	}
+	return p
+}
+
+func (h *vanityHost) serveIndex(w http.ResponseWriter, r *http.Request, host string) {
+	var buf bytes.Buffer
+	page := h.indexPage(host, r.URL.Query())
+	if err := h.indexTmpl.Execute(&buf, struct {
+		Host string
+		indexPage
+		Groups    []indexGroup
+		Analytics template.HTML
+	}{
+		Host:      host,
+		indexPage: page,
+		Groups:    indexGroups(page.Paths),
+		Analytics: h.analytics,
+	}); err != nil {
+		logRenderError(r, "index_template", err)
+		httpError(w, r, "cannot render the page", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (h *vanityHost) serveIndexJSON(w http.ResponseWriter, r *http.Request, host string) {
+	page := h.indexPage(host, r.URL.Query())
+	writeJSON(w, r, http.StatusOK, struct {
+		Host  string       `json:"host"`
+		Query string       `json:"query,omitempty"`
+		Page  int          `json:"page"`
+		Pages int          `json:"pages"`
+		Total int          `json:"total"`
+		Paths []indexEntry `json:"paths"`
+	}{
+		Host:  host,
+		Query: page.Query,
+		Page:  page.Page,
+		Pages: page.Pages,
+		Total: page.Total,
+		Paths: page.Paths,
+	})
+}
+
+// wantsJSON reports whether r's Accept header prefers JSON to HTML.
+func wantsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
+}
+
+var indexTmpl, _ = template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<title>{{.Host}}</title>
+{{with .Analytics}}{{.}}
+{{end}}</head>
+<body>
+<h1>{{.Host}}</h1>
+{{if or .Query (gt .Pages 1)}}<form method="get"><input type="search" name="q" value="{{.Query}}" placeholder="Search"></form>
+{{end}}{{range .Groups}}{{if .Name}}<h2>{{.Name}}</h2>
+{{end}}<ul>
+{{range .Paths}}<li><a href="{{.DocsURL}}">{{.Import}}</a>{{if .Description}} &mdash; {{.Description}}{{end}}</li>
+{{end}}</ul>
+{{end}}{{if gt .Pages 1}}<p>{{with .PrevURL}}<a href="{{.}}">Previous</a> {{end}}Page {{.Page}} of {{.Pages}}{{with .NextURL}} <a href="{{.}}">Next</a>{{end}}</p>
+{{end}}</body>
+</html>`)
@@ -0,0 +1,184 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codehost
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// branchCacheTTL is how long a detected default branch is reused from the
+// branch cache file before asking the host again.
+const branchCacheTTL = 24 * time.Hour
+
+type branchCacheEntry struct {
+	Branch string    `json:"branch"`
+	Time   time.Time `json:"time"`
+}
+
+// DetectDefaultBranches asks the hosting service of each repo for its
+// default branch and returns a map from repo URL to branch name. Repos
+// on unrecognized hosts or whose lookup fails are omitted, and the
+// failures logged to logger. If cacheFile is not empty, recent results
+// are read from and saved to it.
+func (h Hosts) DetectDefaultBranches(repos []string, cacheFile string, logger *slog.Logger) map[string]string {
+	cache := make(map[string]branchCacheEntry)
+	if cacheFile != "" {
+		if data, err := ioutil.ReadFile(cacheFile); err == nil {
+			if err := json.Unmarshal(data, &cache); err != nil {
+				logger.Warn("cannot read branch cache", "file", cacheFile, "err", err)
+			}
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, 8)
+		updated bool
+	)
+	now := time.Now()
+	for _, repo := range repos {
+		if e, ok := cache[repo]; ok && now.Sub(e.Time) < branchCacheTTL {
+			continue
+		}
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			branch, err := h.FetchDefaultBranch(repo)
+			if err != nil {
+				logger.Warn("cannot detect default branch", "repo", repo, "err", err)
+				return
+			}
+			if branch == "" {
+				return
+			}
+			mu.Lock()
+			cache[repo] = branchCacheEntry{Branch: branch, Time: now}
+			updated = true
+			mu.Unlock()
+		}(repo)
+	}
+	wg.Wait()
+
+	if cacheFile != "" && updated {
+		if data, err := json.MarshalIndent(cache, "", "\t"); err != nil {
+			logger.Warn("cannot write branch cache", "file", cacheFile, "err", err)
+		} else if err := ioutil.WriteFile(cacheFile, data, 0666); err != nil {
+			logger.Warn("cannot write branch cache", "file", cacheFile, "err", err)
+		}
+	}
+	branches := make(map[string]string, len(repos))
+	for _, repo := range repos {
+		if e, ok := cache[repo]; ok {
+			branches[repo] = e.Branch
+		}
+	}
+	return branches
+}
+
+// FetchDefaultBranch queries the GitHub (including GitHub Enterprise),
+// GitLab, or Bitbucket API for the default branch of repo. API tokens are
+// read from the GITHUB_TOKEN, GITLAB_TOKEN, and BITBUCKET_TOKEN
+// environment variables, or for GitHub Enterprise, from h.GitHubTokens.
+// It returns an empty string for repos on other hosts.
+func (h Hosts) FetchDefaultBranch(repo string) (string, error) {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	var (
+		apiURL string
+		header = make(http.Header)
+		result struct {
+			DefaultBranch string `json:"default_branch"`
+			MainBranch    struct {
+				Name string `json:"name"`
+			} `json:"mainbranch"`
+		}
+	)
+	switch {
+	case h.IsGitHub(repo):
+		if u.Host == "github.com" {
+			apiURL = "https://api.github.com/repos/" + name
+		} else {
+			apiURL = "https://" + u.Host + "/api/v3/repos/" + name
+		}
+		header.Set("Accept", "application/vnd.github+json")
+		if tok := h.GitHubToken(u.Host); tok != "" {
+			header.Set("Authorization", "Bearer "+tok)
+		}
+	case u.Host == "gitlab.com":
+		apiURL = "https://gitlab.com/api/v4/projects/" + url.PathEscape(name)
+		if tok := os.Getenv("GITLAB_TOKEN"); tok != "" {
+			header.Set("PRIVATE-TOKEN", tok)
+		}
+	case u.Host == "bitbucket.org":
+		apiURL = "https://api.bitbucket.org/2.0/repositories/" + name
+		if tok := os.Getenv("BITBUCKET_TOKEN"); tok != "" {
+			header.Set("Authorization", "Bearer "+tok)
+		}
+	default:
+		return "", nil
+	}
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = header
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", apiURL, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("%s: %v", apiURL, err)
+	}
+	if result.DefaultBranch != "" {
+		return result.DefaultBranch, nil
+	}
+	return result.MainBranch.Name, nil
+}
+
+// GitHubToken returns the API token for the GitHub server at host:
+// $GITHUB_TOKEN for github.com, or the one for host in h.GitHubTokens for
+// GitHub Enterprise. The token for one is never sent to another.
+func (h Hosts) GitHubToken(host string) string {
+	if strings.EqualFold(host, "github.com") || strings.EqualFold(host, "api.github.com") {
+		return os.Getenv("GITHUB_TOKEN")
+	}
+	for name, tok := range h.GitHubTokens {
+		if strings.EqualFold(name, host) {
+			return tok
+		}
+	}
+	return ""
+}
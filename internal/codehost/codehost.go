@@ -0,0 +1,194 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package codehost recognizes the repos served at vanity import paths. It
+// expands the shorthands accepted in the config, finds the web URL of SSH
+// and Bitbucket Server repos, builds the go-source tags of repos on known
+// code hosts, and detects their default branches. Both the govanityurls
+// app and package vanity use it.
+package codehost
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Hosts describes the self-hosted code hosts whose repos are treated like
+// those on the public ones. The zero value recognizes only the public
+// hosts.
+type Hosts struct {
+	// GitHub lists the hostnames of GitHub Enterprise servers, and
+	// GitHubTokens maps their hostnames to the API tokens for them.
+	GitHub       []string
+	GitHubTokens map[string]string
+	// BitbucketServer lists the hostnames of Bitbucket Server (or Data
+	// Center) instances.
+	BitbucketServer []string
+}
+
+// shorthands maps repo prefixes accepted in the config to the URLs they
+// expand to.
+var shorthands = []struct{ prefix, url string }{
+	{"gh:", "https://github.com/"},
+	{"gl:", "https://gitlab.com/"},
+	{"bb:", "https://bitbucket.org/"},
+}
+
+// Expand expands shorthand repo values like "gh:user/repo" or
+// "github.com/user/repo" into full HTTPS URLs. Other values are
+// returned unchanged.
+func Expand(repo string) string {
+	for _, s := range shorthands {
+		if strings.HasPrefix(repo, s.prefix) {
+			return s.url + strings.TrimPrefix(repo, s.prefix)
+		}
+	}
+	if strings.Contains(repo, ":") {
+		return repo
+	}
+	if i := strings.Index(repo, "/"); i > 0 && strings.Contains(repo[:i], ".") {
+		return "https://" + repo
+	}
+	return repo
+}
+
+// ParseSSH parses an SSH repo URL, either as an ssh:// URL or in the
+// scp-like "git@github.com:user/repo.git" form. It returns the equivalent
+// HTTPS URL along with the repo in ssh:// form, which is the only form the
+// go command accepts in go-import tags.
+func ParseSSH(repo string) (web, ssh string, ok bool) {
+	var host, path string
+	switch {
+	case strings.HasPrefix(repo, "ssh://") || strings.HasPrefix(repo, "git+ssh://"):
+		u, err := url.Parse(repo)
+		if err != nil || u.Hostname() == "" {
+			return "", "", false
+		}
+		host, path, ssh = u.Hostname(), u.Path, repo
+	case !strings.Contains(repo, "://"):
+		i := strings.Index(repo, ":")
+		if i <= 0 || strings.Contains(repo[:i], "/") {
+			return "", "", false
+		}
+		userHost := repo[:i]
+		host = userHost[strings.LastIndex(userHost, "@")+1:]
+		path = "/" + strings.TrimPrefix(repo[i+1:], "/")
+		ssh = "ssh://" + userHost + path
+	default:
+		return "", "", false
+	}
+	web = "https://" + host + strings.TrimSuffix(strings.TrimSuffix(path, "/"), ".git")
+	return web, ssh, true
+}
+
+// IsGitHub reports whether repo is hosted on github.com or one of the
+// GitHub Enterprise servers of h.
+func (h Hosts) IsGitHub(repo string) bool {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return false
+	}
+	return u.Host == "github.com" || hasHost(h.GitHub, u.Host)
+}
+
+// IsGitLab reports whether repo is hosted on gitlab.com.
+func IsGitLab(repo string) bool {
+	u, err := url.Parse(repo)
+	return err == nil && u.Host == "gitlab.com"
+}
+
+// IsBitbucketCloud reports whether repo is hosted on bitbucket.org.
+func IsBitbucketCloud(repo string) bool {
+	u, err := url.Parse(repo)
+	return err == nil && u.Host == "bitbucket.org"
+}
+
+// IsBitbucketServer reports whether repo is hosted on one of the
+// Bitbucket Server instances of h.
+func (h Hosts) IsBitbucketServer(repo string) bool {
+	u, err := url.Parse(repo)
+	return err == nil && hasHost(h.BitbucketServer, u.Host)
+}
+
+// hasHost reports whether hosts includes host, ignoring case.
+func hasHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if strings.EqualFold(host, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseBitbucketServer parses either the clone URL
+// (https://host/scm/KEY/name.git) or the web URL
+// (https://host/projects/KEY/repos/name) of a repo on one of the
+// Bitbucket Server instances of h and returns both forms.
+func (h Hosts) ParseBitbucketServer(repo string) (clone, home string, ok bool) {
+	if !h.IsBitbucketServer(repo) {
+		return "", "", false
+	}
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	// Bitbucket Server may be installed under a context path, so look for
+	// the repo at the end of the path.
+	var base, key, name string
+	switch n := len(parts); {
+	case n >= 3 && parts[n-3] == "scm":
+		base, key, name = strings.Join(parts[:n-3], "/"), parts[n-2], strings.TrimSuffix(parts[n-1], ".git")
+	case n >= 4 && parts[n-4] == "projects" && parts[n-2] == "repos":
+		base, key, name = strings.Join(parts[:n-4], "/"), parts[n-3], parts[n-1]
+	default:
+		return "", "", false
+	}
+	root := u.Scheme + "://" + u.Host + "/"
+	if base != "" {
+		root += base + "/"
+	}
+	return root + "scm/" + strings.ToLower(key) + "/" + name + ".git",
+		root + "projects/" + strings.ToUpper(key) + "/repos/" + name, true
+}
+
+// IsLaunchpad reports whether repo is a Launchpad project URL.
+func IsLaunchpad(repo string) bool {
+	return strings.HasPrefix(repo, "https://launchpad.net/")
+}
+
+// Display returns the content of the go-source meta tag, without the
+// import prefix, for the repo at web, with source links to branch. It
+// returns empty if the repo's host isn't known.
+func (h Hosts) Display(web, vcs, branch string) string {
+	switch {
+	case h.IsGitHub(web):
+		return fmt.Sprintf("%v %v/tree/%v{/dir} %v/blob/%v{/dir}/{file}#L{line}", web, web, branch, web, branch)
+	case IsGitLab(web):
+		return fmt.Sprintf("%v %v/-/tree/%v{/dir} %v/-/blob/%v{/dir}/{file}#L{line}", web, web, branch, web, branch)
+	case IsBitbucketCloud(web):
+		return fmt.Sprintf("%v %v/src/%v{/dir} %v/src/%v{/dir}/{file}#lines-{line}", web, web, branch, web, branch)
+	case h.IsBitbucketServer(web):
+		return fmt.Sprintf("%v %v/browse{/dir}?at=refs/heads/%v %v/browse{/dir}/{file}?at=refs/heads/%v#{line}", web, web, branch, web, branch)
+	case IsLaunchpad(web) && vcs == "bzr":
+		// Loggerhead serves the development focus of a project (or a
+		// specific branch) under bazaar.launchpad.net/+branch/.
+		branch := "https://bazaar.launchpad.net/+branch/" + strings.TrimPrefix(web, "https://launchpad.net/")
+		return fmt.Sprintf("%v %v/files/head:{/dir} %v/view/head:{/dir}/{file}#L{line}", web, branch, branch)
+	case vcs == "fossil":
+		return fmt.Sprintf("%v %v/dir?ci=tip&name={dir} %v/file{/dir}/{file}?ci=tip&ln={line}", web, web, web)
+	}
+	return ""
+}
@@ -0,0 +1,210 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codehost
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		repo string
+		want string
+	}{
+		{"gh:rakyll/portmidi", "https://github.com/rakyll/portmidi"},
+		{"gl:rakyll/portmidi", "https://gitlab.com/rakyll/portmidi"},
+		{"bb:rakyll/portmidi", "https://bitbucket.org/rakyll/portmidi"},
+		{"github.com/rakyll/portmidi", "https://github.com/rakyll/portmidi"},
+		{"github.com/rakyll/portmidi.git", "https://github.com/rakyll/portmidi.git"},
+		{"https://github.com/rakyll/portmidi", "https://github.com/rakyll/portmidi"},
+		{"git@github.com:rakyll/portmidi.git", "git@github.com:rakyll/portmidi.git"},
+		{"ssh://git@github.com/rakyll/portmidi.git", "ssh://git@github.com/rakyll/portmidi.git"},
+		// Without a dot in the first element, there is no host to expand.
+		{"rakyll/portmidi", "rakyll/portmidi"},
+		{"portmidi", "portmidi"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := Expand(test.repo); got != test.want {
+			t.Errorf("Expand(%q) = %q; want %q", test.repo, got, test.want)
+		}
+	}
+}
+
+func TestParseSSH(t *testing.T) {
+	tests := []struct {
+		repo string
+		web  string
+		ssh  string
+		ok   bool
+	}{
+		{
+			repo: "git@github.com:rakyll/portmidi.git",
+			web:  "https://github.com/rakyll/portmidi",
+			ssh:  "ssh://git@github.com/rakyll/portmidi.git",
+			ok:   true,
+		},
+		{
+			repo: "git@github.com:rakyll/portmidi",
+			web:  "https://github.com/rakyll/portmidi",
+			ssh:  "ssh://git@github.com/rakyll/portmidi",
+			ok:   true,
+		},
+		{
+			repo: "git@github.com:/rakyll/portmidi.git",
+			web:  "https://github.com/rakyll/portmidi",
+			ssh:  "ssh://git@github.com/rakyll/portmidi.git",
+			ok:   true,
+		},
+		{
+			repo: "github.com:rakyll/portmidi.git",
+			web:  "https://github.com/rakyll/portmidi",
+			ssh:  "ssh://github.com/rakyll/portmidi.git",
+			ok:   true,
+		},
+		{
+			repo: "git@github.com:rakyll/portmidi/",
+			web:  "https://github.com/rakyll/portmidi",
+			ssh:  "ssh://git@github.com/rakyll/portmidi/",
+			ok:   true,
+		},
+		{
+			repo: "ssh://git@github.com/rakyll/portmidi.git",
+			web:  "https://github.com/rakyll/portmidi",
+			ssh:  "ssh://git@github.com/rakyll/portmidi.git",
+			ok:   true,
+		},
+		{
+			repo: "ssh://git@git.example.com:2222/team/portmidi.git",
+			web:  "https://git.example.com/team/portmidi",
+			ssh:  "ssh://git@git.example.com:2222/team/portmidi.git",
+			ok:   true,
+		},
+		{
+			repo: "git+ssh://git@github.com/rakyll/portmidi.git/",
+			web:  "https://github.com/rakyll/portmidi",
+			ssh:  "git+ssh://git@github.com/rakyll/portmidi.git/",
+			ok:   true,
+		},
+		{repo: "ssh:///rakyll/portmidi.git"},
+		{repo: "https://github.com/rakyll/portmidi"},
+		{repo: "github.com/rakyll/portmidi"},
+		{repo: "rakyll/portmidi:v1"},
+		{repo: ":rakyll/portmidi"},
+		{repo: ""},
+	}
+	for _, test := range tests {
+		web, ssh, ok := ParseSSH(test.repo)
+		if web != test.web || ssh != test.ssh || ok != test.ok {
+			t.Errorf("ParseSSH(%q) = %q, %q, %t; want %q, %q, %t", test.repo, web, ssh, ok, test.web, test.ssh, test.ok)
+		}
+	}
+}
+
+func TestParseBitbucketServer(t *testing.T) {
+	h := Hosts{BitbucketServer: []string{"bitbucket.example.com"}}
+	tests := []struct {
+		repo  string
+		clone string
+		home  string
+		ok    bool
+	}{
+		{
+			repo:  "https://bitbucket.example.com/scm/proj/portmidi.git",
+			clone: "https://bitbucket.example.com/scm/proj/portmidi.git",
+			home:  "https://bitbucket.example.com/projects/PROJ/repos/portmidi",
+			ok:    true,
+		},
+		{
+			repo:  "https://bitbucket.example.com/scm/proj/portmidi",
+			clone: "https://bitbucket.example.com/scm/proj/portmidi.git",
+			home:  "https://bitbucket.example.com/projects/PROJ/repos/portmidi",
+			ok:    true,
+		},
+		{
+			repo:  "https://bitbucket.example.com/projects/PROJ/repos/portmidi",
+			clone: "https://bitbucket.example.com/scm/proj/portmidi.git",
+			home:  "https://bitbucket.example.com/projects/PROJ/repos/portmidi",
+			ok:    true,
+		},
+		{
+			repo:  "https://bitbucket.example.com/projects/PROJ/repos/portmidi/",
+			clone: "https://bitbucket.example.com/scm/proj/portmidi.git",
+			home:  "https://bitbucket.example.com/projects/PROJ/repos/portmidi",
+			ok:    true,
+		},
+		{
+			// Installed under a context path.
+			repo:  "https://bitbucket.example.com/bitbucket/scm/proj/portmidi.git",
+			clone: "https://bitbucket.example.com/bitbucket/scm/proj/portmidi.git",
+			home:  "https://bitbucket.example.com/bitbucket/projects/PROJ/repos/portmidi",
+			ok:    true,
+		},
+		{
+			repo:  "https://BITBUCKET.example.com/scm/proj/portmidi.git",
+			clone: "https://BITBUCKET.example.com/scm/proj/portmidi.git",
+			home:  "https://BITBUCKET.example.com/projects/PROJ/repos/portmidi",
+			ok:    true,
+		},
+		{repo: "https://bitbucket.example.com/projects/PROJ/repos/portmidi/browse"},
+		{repo: "https://bitbucket.example.com/portmidi"},
+		{repo: "https://github.com/scm/proj/portmidi.git"},
+		{repo: "https://bitbucket.org/rakyll/portmidi"},
+	}
+	for _, test := range tests {
+		clone, home, ok := h.ParseBitbucketServer(test.repo)
+		if clone != test.clone || home != test.home || ok != test.ok {
+			t.Errorf("ParseBitbucketServer(%q) = %q, %q, %t; want %q, %q, %t", test.repo, clone, home, ok, test.clone, test.home, test.ok)
+		}
+	}
+}
+
+// sourceFileURL fills in the file template of the go-source content
+// display for a file in dir, which is empty at the root of the repo, as
+// the go command's documentation tools do.
+func sourceFileURL(display, dir, file, line string) string {
+	fields := strings.Fields(display)
+	slashDir := dir
+	if dir != "" {
+		slashDir = "/" + dir
+	}
+	return strings.NewReplacer("{dir}", dir, "{/dir}", slashDir, "{file}", file, "{line}", line).Replace(fields[len(fields)-1])
+}
+
+func TestDisplayFileURL(t *testing.T) {
+	tests := []struct {
+		web  string
+		vcs  string
+		dir  string
+		want string
+	}{
+		{"https://github.com/rakyll/portmidi", "git", "", "https://github.com/rakyll/portmidi/blob/main/main.go#L7"},
+		{"https://github.com/rakyll/portmidi", "git", "sub/pkg", "https://github.com/rakyll/portmidi/blob/main/sub/pkg/main.go#L7"},
+		{"https://launchpad.net/portmidi", "bzr", "", "https://bazaar.launchpad.net/+branch/portmidi/view/head:/main.go#L7"},
+		{"https://fossil.example.com/portmidi", "fossil", "", "https://fossil.example.com/portmidi/file/main.go?ci=tip&ln=7"},
+		{"https://fossil.example.com/portmidi", "fossil", "sub/pkg", "https://fossil.example.com/portmidi/file/sub/pkg/main.go?ci=tip&ln=7"},
+	}
+	for _, test := range tests {
+		display := Hosts{}.Display(test.web, test.vcs, "main")
+		if display == "" {
+			t.Errorf("Display(%q, %q, %q) = \"\"; want source links", test.web, test.vcs, "main")
+			continue
+		}
+		if got := sourceFileURL(display, test.dir, "main.go", "7"); got != test.want {
+			t.Errorf("for main.go in %q of %s, Display(%q, %q, %q) links to %q; want %q", test.dir, test.web, test.web, test.vcs, "main", got, test.want)
+		}
+	}
+}
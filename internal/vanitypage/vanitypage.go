@@ -0,0 +1,176 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vanitypage builds the page served at each vanity import path. It
+// finds the configured path a request falls under, works out where
+// browsers are sent, and renders the go-import and go-source meta tags.
+// It also reads paths from the top level of old configs. Both the
+// govanityurls app and package vanity use it.
+package vanitypage
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	texttemplate "text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Find returns the path that current falls under, among those for which
+// has reports true, along with the rest of current (without a leading
+// slash) as the subpath. Paths that don't start with a slash, like "*",
+// match nothing.
+func Find(current string, has func(path string) bool) (path, subpath string, ok bool) {
+	for path = current; strings.HasPrefix(path, "/"); path = path[:strings.LastIndex(path, "/")] {
+		if has(path) {
+			subpath = strings.TrimPrefix(strings.TrimPrefix(current, path), "/")
+			return path, subpath, true
+		}
+	}
+	return "", "", false
+}
+
+// DocsURL returns the URL of the documentation for importPath, given
+// docsURL, a docs_url template that gets the import path as .Import. The
+// import path is escaped for use in a URL path. If the template fails,
+// DocsURL returns the error along with the package's pkg.go.dev URL.
+func DocsURL(docsURL *texttemplate.Template, importPath string) (string, error) {
+	segments := strings.Split(importPath, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	importPath = strings.Join(segments, "/")
+	var sb strings.Builder
+	if err := docsURL.Execute(&sb, struct{ Import string }{importPath}); err != nil {
+		return "https://pkg.go.dev/" + importPath, err
+	}
+	return sb.String(), nil
+}
+
+// RedirectURL returns the URL that browsers visiting subpath of the
+// package at importPath are sent to, given the path's redirect setting:
+// "docs", "repo", or a URL. web is the web URL of the repo, and docsURL
+// returns the documentation URL of an import path. Documentation links
+// keep the request's query parameters, other than go-get.
+func RedirectURL(redirect, web, importPath, subpath string, query url.Values, docsURL func(importPath string) string) string {
+	switch redirect {
+	case "docs":
+		if subpath != "" {
+			importPath += "/" + subpath
+		}
+		u := docsURL(importPath)
+		query.Del("go-get")
+		if q := query.Encode(); q != "" {
+			if strings.Contains(u, "?") {
+				u += "&" + q
+			} else {
+				u += "?" + q
+			}
+		}
+		return u
+	case "repo":
+		return web
+	default:
+		return redirect
+	}
+}
+
+// LegacyPaths returns the paths given at the top level of the config in
+// data, as they were before they moved under paths:, each decoded into an
+// E as it would be under paths:. Any top-level key that starts with a
+// slash is taken to be a path.
+func LegacyPaths[E any](data []byte) (map[string]E, error) {
+	var top yaml.MapSlice
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	paths := make(map[string]E)
+	for _, item := range top {
+		path, ok := item.Key.(string)
+		if !ok || !strings.HasPrefix(path, "/") {
+			continue
+		}
+		entry, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		var e E
+		if err := yaml.Unmarshal(entry, &e); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		paths[path] = e
+	}
+	return paths, nil
+}
+
+// Page is what the page for a path is rendered from, by Template or by a
+// custom vanity template.
+type Page struct {
+	// Import is the import path, like "example.com/portmidi", and
+	// Subpath is the rest of the requested path, like "sub/pkg".
+	Import  string
+	Subpath string
+	// VCS, Repo, and Display make up the meta tags.
+	VCS     string
+	Repo    string
+	Display string
+	// Redirect is where to send browsers, or empty for the go command
+	// and when Install is set.
+	Redirect string
+	// Canonical is the URL of the package's documentation, which search
+	// engines are pointed at.
+	Canonical string
+	NoIndex   bool
+	// Install, if set, is shown to browsers in place of a redirect.
+	Install *Install
+	// Analytics is an HTML snippet added to the page for browsers.
+	Analytics template.HTML
+	// Data holds the path's data from the config.
+	Data map[string]string
+}
+
+// Install describes how to install a package, for the page shown to
+// browsers when install instructions are turned on.
+type Install struct {
+	Package     string
+	Description string
+	DocsURL     string
+	SourceURL   string
+}
+
+// Template renders the default page for a path from a Page.
+var Template = template.Must(template.New("vanity").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<meta name="go-import" content="{{.Import}} {{.VCS}} {{.Repo}}">
+{{if .Display}}<meta name="go-source" content="{{.Import}} {{.Display}}">{{end}}
+{{if .Redirect}}<meta http-equiv="refresh" content="0; url={{.Redirect}}">{{end}}
+{{with .Canonical}}<link rel="canonical" href="{{.}}">
+{{end}}{{if .NoIndex}}<meta name="robots" content="noindex">
+{{end}}{{with .Install}}<title>{{.Package}}</title>
+{{end}}{{with .Analytics}}{{.}}
+{{end}}</head>
+<body>
+{{with .Install}}<h1>{{.Package}}</h1>
+{{with .Description}}<p>{{.}}</p>
+{{end}}<pre>go get {{.Package}}</pre>
+<p>To install a command:</p>
+<pre>go install {{.Package}}@latest</pre>
+<p><a href="{{.DocsURL}}">Documentation</a> &middot; <a href="{{.SourceURL}}">Source</a></p>
+{{end}}{{if .Redirect}}Nothing to see here; <a href="{{.Redirect}}">move along</a>.{{end}}
+</body>
+</html>`))
@@ -0,0 +1,68 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanitypage
+
+import (
+	"net/url"
+	"testing"
+	texttemplate "text/template"
+)
+
+func TestDocsURL(t *testing.T) {
+	tests := []struct {
+		docsURL    string
+		importPath string
+		want       string
+	}{
+		{"https://pkg.go.dev/{{.Import}}", "example.com/portmidi", "https://pkg.go.dev/example.com/portmidi"},
+		{"https://pkg.go.dev/{{.Import}}", "example.com/a b/c?d", "https://pkg.go.dev/example.com/a%20b/c%3Fd"},
+		{"https://docs.example.com/?pkg={{.Import}}", "example.com/portmidi", "https://docs.example.com/?pkg=example.com/portmidi"},
+	}
+	for _, test := range tests {
+		tmpl := texttemplate.Must(texttemplate.New("docs_url").Parse(test.docsURL))
+		if got, err := DocsURL(tmpl, test.importPath); got != test.want || err != nil {
+			t.Errorf("DocsURL(%q, %q) = %q, %v; want %q, <nil>", test.docsURL, test.importPath, got, err, test.want)
+		}
+	}
+}
+
+func TestRedirectURL(t *testing.T) {
+	docsURL := func(importPath string) string { return "https://pkg.go.dev/" + importPath }
+	docsURLWithQuery := func(importPath string) string { return "https://docs.example.com/?pkg=" + importPath }
+	tests := []struct {
+		redirect string
+		subpath  string
+		query    string
+		docsURL  func(string) string
+		want     string
+	}{
+		{"docs", "", "", docsURL, "https://pkg.go.dev/example.com/portmidi"},
+		{"docs", "sub", "go-get=1", docsURL, "https://pkg.go.dev/example.com/portmidi/sub"},
+		{"docs", "", "tab=versions&go-get=0", docsURL, "https://pkg.go.dev/example.com/portmidi?tab=versions"},
+		{"docs", "", "tab=versions", docsURLWithQuery, "https://docs.example.com/?pkg=example.com/portmidi&tab=versions"},
+		{"repo", "sub", "tab=versions", docsURL, "https://github.com/rakyll/portmidi"},
+		{"https://example.com/", "sub", "tab=versions", docsURL, "https://example.com/"},
+	}
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := RedirectURL(test.redirect, "https://github.com/rakyll/portmidi", "example.com/portmidi", test.subpath, query, test.docsURL)
+		if got != test.want {
+			t.Errorf("RedirectURL(%q, ..., %q, %q) = %q; want %q", test.redirect, test.subpath, test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build lambda
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// main runs the app as an AWS Lambda function behind API Gateway or an
+// Application Load Balancer. The config is read from the file named by
+// $VANITY_CONFIG, which may be an s3://bucket/key URL, or else from
+// vanity.yaml alongside the function.
+func main() {
+	file := os.Getenv("VANITY_CONFIG")
+	if file == "" {
+		file = "vanity.yaml"
+	}
+	if strings.HasPrefix(file, "s3://") {
+		var err error
+		file, err = fetchS3Config(context.Background(), file)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	loadConfig(file)
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+	logConfig()
+	isReady.Store(true)
+	lambda.Start(serveLambda)
+}
+
+// fetchS3Config copies the config at rawURL, an s3://bucket/key URL, to a
+// temporary file and returns its name.
+func fetchS3Config(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("VANITY_CONFIG: %v", err)
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return "", fmt.Errorf("VANITY_CONFIG %q is not an s3://bucket/key URL", rawURL)
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	obj, err := s3.NewFromConfig(cfg).GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("get %s: %v", rawURL, err)
+	}
+	defer obj.Body.Close()
+	file := filepath.Join(os.TempDir(), "vanity.yaml")
+	f, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, obj.Body); err != nil {
+		f.Close()
+		return "", fmt.Errorf("get %s: %v", rawURL, err)
+	}
+	return file, f.Close()
+}
+
+// lambdaRequest is the event for a request from API Gateway, either a
+// REST API or an HTTP API (payload version 2.0), or from an Application
+// Load Balancer.
+type lambdaRequest struct {
+	Version string `json:"version"`
+
+	// REST APIs and load balancers give these.
+	HTTPMethod                      string              `json:"httpMethod"`
+	Path                            string              `json:"path"`
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+
+	// HTTP APIs give these instead.
+	RawPath        string   `json:"rawPath"`
+	RawQueryString string   `json:"rawQueryString"`
+	Cookies        []string `json:"cookies"`
+
+	Headers        map[string]string `json:"headers"`
+	RequestContext struct {
+		HTTP struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+		Identity struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+		ELB *struct{} `json:"elb"`
+	} `json:"requestContext"`
+	Body            string `json:"body"`
+	IsBase64Encoded bool   `json:"isBase64Encoded"`
+}
+
+// lambdaResponse is the response to a lambdaRequest.
+type lambdaResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	StatusDescription string              `json:"statusDescription,omitempty"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// serveLambda serves the request described by event.
+func serveLambda(ctx context.Context, event *lambdaRequest) (*lambdaResponse, error) {
+	r, err := event.httpRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rec := httptest.NewRecorder()
+	handle(rec, r)
+	resp := &lambdaResponse{StatusCode: rec.Code}
+	header := rec.Header()
+	switch {
+	case event.Version == "2.0":
+		resp.Cookies = header.Values("Set-Cookie")
+		header.Del("Set-Cookie")
+		resp.Headers = make(map[string]string)
+		for k, v := range header {
+			resp.Headers[k] = strings.Join(v, ", ")
+		}
+	case event.RequestContext.ELB != nil && event.MultiValueHeaders == nil:
+		resp.Headers = make(map[string]string)
+		for k, v := range header {
+			resp.Headers[k] = v[0]
+		}
+	default:
+		resp.MultiValueHeaders = header
+	}
+	if event.RequestContext.ELB != nil {
+		resp.StatusDescription = fmt.Sprintf("%d %s", rec.Code, http.StatusText(rec.Code))
+	}
+	body := rec.Body.Bytes()
+	if header.Get("Content-Encoding") != "" || !utf8.Valid(body) {
+		resp.Body, resp.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	} else {
+		resp.Body = string(body)
+	}
+	return resp, nil
+}
+
+// httpRequest returns the request described by e.
+func (e *lambdaRequest) httpRequest(ctx context.Context) (*http.Request, error) {
+	method, path, query := e.HTTPMethod, e.Path, ""
+	switch {
+	case e.Version == "2.0":
+		method, path, query = e.RequestContext.HTTP.Method, e.RawPath, e.RawQueryString
+	case e.RequestContext.ELB != nil:
+		// Load balancers pass the query on as it was sent.
+		var params []string
+		for k, vs := range e.MultiValueQueryStringParameters {
+			for _, v := range vs {
+				params = append(params, k+"="+v)
+			}
+		}
+		if e.MultiValueQueryStringParameters == nil {
+			for k, v := range e.QueryStringParameters {
+				params = append(params, k+"="+v)
+			}
+		}
+		query = strings.Join(params, "&")
+	default:
+		q := url.Values(e.MultiValueQueryStringParameters)
+		if q == nil {
+			q = make(url.Values)
+			for k, v := range e.QueryStringParameters {
+				q.Set(k, v)
+			}
+		}
+		query = q.Encode()
+	}
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(e.Body)
+		if err != nil {
+			return nil, fmt.Errorf("request body: %v", err)
+		}
+	}
+	u := &url.URL{Path: path, RawQuery: query}
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = u.RequestURI()
+	for k, vs := range e.MultiValueHeaders {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	if e.MultiValueHeaders == nil {
+		for k, v := range e.Headers {
+			r.Header.Set(k, v)
+		}
+	}
+	for _, c := range e.Cookies {
+		r.Header.Add("Cookie", c)
+	}
+	r.Host = r.Header.Get("Host")
+	sourceIP := e.RequestContext.HTTP.SourceIP
+	if sourceIP == "" {
+		sourceIP = e.RequestContext.Identity.SourceIP
+	}
+	if sourceIP != "" {
+		r.RemoteAddr = net.JoinHostPort(sourceIP, "0")
+	}
+	return r, nil
+}
+
+// defaultHost returns the host that a trusted proxy forwarded r for, or
+// else the host r was sent to.
+func defaultHost(r *http.Request) string {
+	return requestHost(r)
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+var (
+	// logLevel is the least severe level logged: debug, info, warn, or
+	// error.
+	logLevel = "info"
+	// logFormat is the format of the log: text or json.
+	logFormat = "text"
+)
+
+// setupLogging sends the log, including that of the log package, to
+// standard error at logLevel in logFormat.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("log_level: %v", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch logFormat {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("log_format must be text or json")
+	}
+	slog.SetDefault(slog.New(requestIDHandler{h}))
+	return nil
+}
+
+// logConfig logs a summary of the config that was loaded.
+func logConfig() {
+	paths := 0
+	for _, h := range hosts {
+		paths += len(h.pathMap())
+	}
+	slog.Info("loaded config", "file", configFile, "hosts", len(hosts), "paths", paths, "path_prefix", pathPrefix, "strict_host", strictHost)
+}
+
+// logRenderError logs and reports that the response to r couldn't be
+// rendered, which would otherwise only be seen by the client as a 500.
+func logRenderError(r *http.Request, what string, err error) {
+	slog.ErrorContext(r.Context(), "cannot render response", "what", what, "host", r.Host, "path", r.URL.Path, "err", err)
+	reportError(r, fmt.Errorf("render %s: %w", what, err))
+}
@@ -12,77 +12,913 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package main contains an App Engine that serves vanity URLs for git repos.
+// Package main contains an App Engine app that serves vanity URLs for git
+// repos. It can also be run as a standalone server.
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
-	"google.golang.org/appengine"
+	"github.com/GoogleCloudPlatform/govanityurls/internal/codehost"
+	"github.com/GoogleCloudPlatform/govanityurls/internal/vanitypage"
 	"gopkg.in/yaml.v2"
 )
 
-var m map[string]struct {
+type pathConfig struct {
 	Repo    string `yaml:"repo,omitempty"`
 	Display string `yaml:"display,omitempty"`
+	VCS     string `yaml:"vcs,omitempty"`
+	Branch  string `yaml:"branch,omitempty"`
+	Proxy   bool   `yaml:"proxy,omitempty"`
+	KeepSSH bool   `yaml:"keep_ssh,omitempty"`
+
+	Description string `yaml:"description,omitempty"`
+	// Group is the heading the path is listed under on the index page.
+	Group string `yaml:"group,omitempty"`
+	// Added is the date the path was added, as YYYY-MM-DD, for sorting
+	// the index page by recency.
+	Added string `yaml:"added,omitempty"`
+
+	// Redirect is where browsers are sent: "docs", "repo", or a URL.
+	Redirect string `yaml:"redirect,omitempty"`
+	// RedirectMode is how browsers are sent there: "meta", for a page
+	// with a meta refresh tag, or "http", for an HTTP redirect.
+	RedirectMode string `yaml:"redirect_mode,omitempty"`
+
+	// NoIndex asks search engines not to index the path's pages.
+	NoIndex bool `yaml:"noindex,omitempty"`
+
+	// Packages lists the packages below the path, relative to it, for
+	// static sites to have a page for each.
+	Packages []string `yaml:"packages,omitempty"`
+
+	// Headers are added to responses for the path.
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Data holds values of the config's choosing for custom templates,
+	// like a build badge URL.
+	Data map[string]string `yaml:"data,omitempty"`
+
+	// Browser names a self-hosted source browser, like "cgit", whose
+	// page for the repo is BrowserURL.
+	Browser    string `yaml:"browser,omitempty"`
+	BrowserURL string `yaml:"browser_url,omitempty"`
+
+	// web is the HTTPS URL of the repo, used for source links.
+	web string
+	// added is Added, parsed.
+	added time.Time
+}
+
+var (
+	// configFile is the file the config was loaded from, configData its
+	// contents, and configHash their hex SHA-256.
+	configFile string
+	configData []byte
+	configHash string
+	// loadTime is when the config was loaded.
+	loadTime time.Time
+)
+
+// codeHosts lists the GitHub Enterprise servers and Bitbucket Server
+// instances of the config.
+var codeHosts codehost.Hosts
+
+// vanityTemplateFile is the vanity template given with -vanity-template,
+// which replaces the one at the top level of the config, if set.
+var vanityTemplateFile string
+
+// listenAddrs lists the addresses the standalone server listens on, as
+// given in the config.
+var listenAddrs []string
+
+// socketMode is the permissions given to unix sockets, in octal, or empty
+// to leave them as created.
+var socketMode string
+
+// timeoutsConfig holds the timeouts of the standalone server. See
+// http.Server for their meaning.
+type timeoutsConfig struct {
+	ReadHeader time.Duration `yaml:"read_header,omitempty"`
+	Read       time.Duration `yaml:"read,omitempty"`
+	Write      time.Duration `yaml:"write,omitempty"`
+	Idle       time.Duration `yaml:"idle,omitempty"`
+}
+
+// timeouts are the timeouts of the standalone server. Writes are allowed
+// plenty of time, since the module proxy may have to fetch a large module
+// before responding.
+var timeouts = timeoutsConfig{
+	ReadHeader: 10 * time.Second,
+	Read:       30 * time.Second,
+	Write:      5 * time.Minute,
+	Idle:       2 * time.Minute,
+}
+
+// override replaces the timeouts that are set in c2.
+func (c *timeoutsConfig) override(c2 timeoutsConfig) {
+	if c2.ReadHeader != 0 {
+		c.ReadHeader = c2.ReadHeader
+	}
+	if c2.Read != 0 {
+		c.Read = c2.Read
+	}
+	if c2.Write != 0 {
+		c.Write = c2.Write
+	}
+	if c2.Idle != 0 {
+		c.Idle = c2.Idle
+	}
 }
 
-func init() {
-	vanity, err := ioutil.ReadFile("./vanity.yaml")
+var (
+	// tlsCert and tlsKey name the files of the certificate served by the
+	// standalone server. If empty, it serves plain HTTP.
+	tlsCert, tlsKey string
+	// h2c is set to accept HTTP/2 connections without TLS.
+	h2c bool
+	// acmeCache is the directory in which certificates obtained from
+	// Let's Encrypt are kept. If empty, certificates aren't obtained
+	// automatically.
+	acmeCache string
+	// acmeEmail is the contact address given to Let's Encrypt.
+	acmeEmail string
+	// drainTimeout is how long the standalone server waits for requests in
+	// progress to finish when shutting down.
+	drainTimeout = 30 * time.Second
+	// http3Addr is the UDP address to serve HTTP/3 on, if any.
+	http3Addr string
+	// httpRedirectAddr is the address of a plain HTTP listener that
+	// redirects to HTTPS, if any.
+	httpRedirectAddr string
+	// maxHeaderBytes caps the size of the request headers accepted by the
+	// standalone server.
+	maxHeaderBytes = 32 << 10
+	// maxBodyBytes caps the size of request bodies. None of the handlers
+	// read a body, so by default none is accepted.
+	maxBodyBytes int64
+	// adminAddr is the address of a listener that serves the management
+	// endpoints of the standalone server in place of the public listeners.
+	adminAddr string
+	// adminTLSCert and adminTLSKey name the files of the certificate
+	// served by the admin listener, and adminClientCA the CA certificates
+	// that client certificates must be signed by. If empty, the admin
+	// listener serves plain HTTP, or doesn't ask for client certificates.
+	adminTLSCert, adminTLSKey string
+	adminClientCA             string
+	// adminOIDC configures logging in to the management endpoints with
+	// OpenID Connect, if its issuer is set.
+	adminOIDC oidcConfig
+	// pprofEnabled is set to serve profiles of the standalone server.
+	pprofEnabled bool
+	// expvarEnabled is set to serve the counters of the standalone server.
+	expvarEnabled bool
+	// metricsEnabled is set to serve Prometheus metrics from the
+	// standalone server.
+	metricsEnabled bool
+	// otlpEndpoint is the URL of the OpenTelemetry collector that the
+	// standalone server sends traces to, if any.
+	otlpEndpoint string
+	// sentryDSN is the DSN of the Sentry project that the standalone server
+	// reports errors to, if any.
+	sentryDSN string
+	// statsdAddr is the address of the StatsD server that the standalone
+	// server sends metrics to, if any, and statsdTags are the DogStatsD
+	// tags added to every metric.
+	statsdAddr string
+	statsdTags []string
+)
+
+// loadConfig reads the config from file, exiting if it is invalid.
+func loadConfig(file string) {
+	vanity, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var parsed struct {
+		hostConfig           `yaml:",inline"`
+		LogLevel             string            `yaml:"log_level,omitempty"`
+		LogFormat            string            `yaml:"log_format,omitempty"`
+		AccessLog            string            `yaml:"access_log,omitempty"`
+		AuditLog             string            `yaml:"audit_log,omitempty"`
+		AuditWebhook         string            `yaml:"audit_webhook,omitempty"`
+		Maintenance          bool              `yaml:"maintenance,omitempty"`
+		MaintenanceFile      string            `yaml:"maintenance_file,omitempty"`
+		MaintenanceRetry     time.Duration     `yaml:"maintenance_retry_after,omitempty"`
+		RateLimit            float64           `yaml:"rate_limit,omitempty"`
+		RateLimitBurst       int               `yaml:"rate_limit_burst,omitempty"`
+		Listen               []string          `yaml:"listen,omitempty"`
+		SocketMode           string            `yaml:"socket_mode,omitempty"`
+		TLSCert              string            `yaml:"tls_cert,omitempty"`
+		TLSKey               string            `yaml:"tls_key,omitempty"`
+		HTTPRedirect         string            `yaml:"http_redirect,omitempty"`
+		H2C                  bool              `yaml:"h2c,omitempty"`
+		HTTP3                string            `yaml:"http3,omitempty"`
+		DrainTimeout         time.Duration     `yaml:"drain_timeout,omitempty"`
+		Timeouts             timeoutsConfig    `yaml:"timeouts,omitempty"`
+		MaxHeaderBytes       int               `yaml:"max_header_bytes,omitempty"`
+		MaxBodyBytes         int64             `yaml:"max_body_bytes,omitempty"`
+		AdminListen          string            `yaml:"admin_listen,omitempty"`
+		AdminTLSCert         string            `yaml:"admin_tls_cert,omitempty"`
+		AdminTLSKey          string            `yaml:"admin_tls_key,omitempty"`
+		AdminClientCA        string            `yaml:"admin_client_ca,omitempty"`
+		AdminOIDC            oidcConfig        `yaml:"admin_oidc,omitempty"`
+		Pprof                bool              `yaml:"pprof,omitempty"`
+		Expvar               bool              `yaml:"expvar,omitempty"`
+		Metrics              bool              `yaml:"metrics,omitempty"`
+		OTLPEndpoint         string            `yaml:"otlp_endpoint,omitempty"`
+		Statsd               string            `yaml:"statsd,omitempty"`
+		SentryDSN            string            `yaml:"sentry_dsn,omitempty"`
+		StatsdTags           []string          `yaml:"statsd_tags,omitempty"`
+		ACMECache            string            `yaml:"acme_cache,omitempty"`
+		ACMEEmail            string            `yaml:"acme_email,omitempty"`
+		Hosts                []hostConfig      `yaml:"hosts,omitempty"`
+		StrictHost           bool              `yaml:"strict_host,omitempty"`
+		AllowedHosts         []string          `yaml:"allowed_hosts,omitempty"`
+		TrustedProxies       []string          `yaml:"trusted_proxies,omitempty"`
+		PathPrefix           string            `yaml:"path_prefix,omitempty"`
+		DefaultBranch        string            `yaml:"default_branch,omitempty"`
+		PathsFile            string            `yaml:"paths_file,omitempty"`
+		DetectBranch         bool              `yaml:"detect_branch,omitempty"`
+		BranchCache          string            `yaml:"branch_cache,omitempty"`
+		GitHubHosts          []string          `yaml:"github_hosts,omitempty"`
+		GitHubTokens         map[string]string `yaml:"github_tokens,omitempty"`
+		BitbucketServerHosts []string          `yaml:"bitbucket_server_hosts,omitempty"`
+		Browsers             map[string]string `yaml:"browsers,omitempty"`
+		Redirect             string            `yaml:"redirect,omitempty"`
+		RedirectMode         string            `yaml:"redirect_mode,omitempty"`
+		RobotsDisallow       []string          `yaml:"robots_disallow,omitempty"`
+		Assets               string            `yaml:"assets,omitempty"`
+		Favicon              string            `yaml:"favicon,omitempty"`
+		HSTS                 *string           `yaml:"hsts,omitempty"`
+		CSP                  *string           `yaml:"content_security_policy,omitempty"`
+		CORS                 corsConfig        `yaml:"cors,omitempty"`
+		Headers              map[string]string `yaml:"headers,omitempty"`
+	}
+	if err := yaml.Unmarshal(vanity, &parsed); err != nil {
+		log.Fatal(err)
+	}
+	if vanityTemplateFile != "" {
+		parsed.VanityTemplate = vanityTemplateFile
+	}
+	legacy, err := vanitypage.LegacyPaths[pathConfig](vanity)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := yaml.Unmarshal(vanity, &m); err != nil {
+	if len(legacy) > 0 {
+		slog.Warn("paths at the top level of the config are deprecated; move them under paths:", "file", file, "paths", len(legacy))
+		if parsed.Paths == nil {
+			parsed.Paths = make(map[string]pathConfig, len(legacy))
+		}
+		for path, e := range legacy {
+			if _, ok := parsed.Paths[path]; ok {
+				log.Fatalf("%s: path is given both at the top level and under paths:", path)
+			}
+			parsed.Paths[path] = e
+		}
+	}
+	if parsed.DefaultBranch == "" {
+		parsed.DefaultBranch = "master"
+	}
+	h, err := newVanityHost(&parsed.hostConfig)
+	if err != nil {
 		log.Fatal(err)
 	}
-	for _, e := range m {
-		if e.Display != "" {
-			continue
+	hosts = []*vanityHost{h}
+	for i := range parsed.Hosts {
+		c := &parsed.Hosts[i]
+		if c.Host == "" {
+			log.Fatalf("hosts[%d]: host is required", i)
 		}
-		if strings.Contains(e.Repo, "github.com") {
-			e.Display = fmt.Sprintf("%v %v/tree/master{/dir} %v/blob/master{/dir}/{file}#L{line}", e.Repo, e.Repo, e.Repo)
+		c.inherit(&parsed.hostConfig)
+		h, err := newVanityHost(c)
+		if err != nil {
+			log.Fatalf("%s: %v", c.Host, err)
 		}
+		hosts = append(hosts, h)
+	}
+	if p := strings.Trim(parsed.PathPrefix, "/"); p != "" {
+		pathPrefix = "/" + p
+	}
+	listenAddrs = parsed.Listen
+	socketMode = parsed.SocketMode
+	tlsCert, tlsKey = parsed.TLSCert, parsed.TLSKey
+	httpRedirectAddr = parsed.HTTPRedirect
+	h2c = parsed.H2C
+	http3Addr = parsed.HTTP3
+	if parsed.DrainTimeout != 0 {
+		drainTimeout = parsed.DrainTimeout
+	}
+	timeouts.override(parsed.Timeouts)
+	if parsed.MaxHeaderBytes < 0 || parsed.MaxBodyBytes < 0 {
+		log.Fatal("max_header_bytes and max_body_bytes must not be negative")
+	}
+	if parsed.MaxHeaderBytes != 0 {
+		maxHeaderBytes = parsed.MaxHeaderBytes
+	}
+	maxBodyBytes = parsed.MaxBodyBytes
+	if parsed.LogLevel != "" {
+		logLevel = parsed.LogLevel
+	}
+	if parsed.LogFormat != "" {
+		logFormat = parsed.LogFormat
+	}
+	accessLog, err = newAccessLog(parsed.AccessLog, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auditLogFile, auditWebhook = parsed.AuditLog, parsed.AuditWebhook
+	maintenance.Store(parsed.Maintenance)
+	maintenanceFile = parsed.MaintenanceFile
+	if parsed.MaintenanceRetry > 0 {
+		maintenanceRetryAfter = parsed.MaintenanceRetry
+	}
+	switch {
+	case parsed.RateLimit < 0 || parsed.RateLimitBurst < 0:
+		log.Fatal("rate_limit and rate_limit_burst must not be negative")
+	case parsed.RateLimit > 0:
+		burst := parsed.RateLimitBurst
+		if burst == 0 {
+			burst = int(math.Max(1, math.Ceil(parsed.RateLimit)))
+		}
+		limiter = newRateLimiter(parsed.RateLimit, burst)
+	default:
+		limiter = nil
+	}
+	adminAddr = parsed.AdminListen
+	adminTLSCert, adminTLSKey = parsed.AdminTLSCert, parsed.AdminTLSKey
+	adminClientCA = parsed.AdminClientCA
+	adminOIDC = parsed.AdminOIDC
+	pprofEnabled = parsed.Pprof
+	expvarEnabled = parsed.Expvar
+	metricsEnabled = parsed.Metrics
+	otlpEndpoint = parsed.OTLPEndpoint
+	statsdAddr, statsdTags = parsed.Statsd, parsed.StatsdTags
+	sentryDSN = parsed.SentryDSN
+	acmeCache, acmeEmail = parsed.ACMECache, parsed.ACMEEmail
+	strictHost = parsed.StrictHost
+	allowedHosts = parsed.AllowedHosts
+	trustedProxies, err = parseTrustedProxies(parsed.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	robotsDisallow = parsed.RobotsDisallow
+	if parsed.HSTS != nil {
+		hsts = *parsed.HSTS
+	}
+	configs := []*hostConfig{&parsed.hostConfig}
+	for i := range parsed.Hosts {
+		configs = append(configs, &parsed.Hosts[i])
+	}
+	switch {
+	case parsed.CSP != nil:
+		csp = *parsed.CSP
+	case hasCustomMarkup(configs...):
+		csp = markupCSP
+	default:
+		csp = defaultCSP
+	}
+	cors = parsed.CORS
+	if err := checkHeaders(parsed.Headers); err != nil {
+		log.Fatalf("headers: %v", err)
+	}
+	extraHeaders = parsed.Headers
+	if parsed.Assets != "" {
+		assets = http.FileServer(http.Dir(parsed.Assets))
+	}
+	if parsed.Favicon != "" {
+		favicon, err = ioutil.ReadFile(parsed.Favicon)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	configFile, configData = file, vanity
+	sum := sha256.Sum256(vanity)
+	configHash = hex.EncodeToString(sum[:])
+	loadTime = time.Now()
+	clearGzipCache()
+	stats.configLoads.Add(1)
+	codeHosts = codehost.Hosts{
+		GitHub:          parsed.GitHubHosts,
+		GitHubTokens:    parsed.GitHubTokens,
+		BitbucketServer: parsed.BitbucketServerHosts,
+	}
+	pathsFile = parsed.PathsFile
+	if pathsFile == "" {
+		pathsFile = strings.TrimSuffix(file, filepath.Ext(file)) + ".paths.yaml"
+	}
+	managed, _, err := readManagedPaths(pathsFile)
+	if err != nil {
+		log.Fatalf("paths_file: %v", err)
+	}
+	for _, h := range hosts {
+		for path, e := range managed.forHost(h.host) {
+			if _, ok := h.paths[path]; ok {
+				slog.Warn("path added through the paths API is also in the config; serving the config's", "host", h.host, "path", path, "paths_file", pathsFile)
+				continue
+			}
+			h.managed[path] = e
+		}
+	}
+	// Paths added through the paths API are checked and filled in like
+	// those in the config.
+	eachPaths := func(f func(h *vanityHost, m map[string]pathConfig)) {
+		for _, h := range hosts {
+			f(h, h.paths)
+			f(h, h.managed)
+		}
+	}
+	eachPaths(func(h *vanityHost, m map[string]pathConfig) {
+		for path, e := range m {
+			m[path] = expandPathRepo(e)
+		}
+	})
+	detectBranch, branchCache = parsed.DetectBranch, parsed.BranchCache
+	var detected map[string]string
+	if detectBranch {
+		var repos []string
+		eachPaths(func(h *vanityHost, m map[string]pathConfig) {
+			for _, e := range m {
+				if e.Branch == "" {
+					repos = append(repos, e.web)
+				}
+			}
+		})
+		detected = codeHosts.DetectDefaultBranches(repos, branchCache, slog.Default())
+	}
+	defaultBranch = parsed.DefaultBranch
+	defaultRedirect, defaultRedirectMode = parsed.Redirect, parsed.RedirectMode
+	browsers = parsed.Browsers
+	eachPaths(func(h *vanityHost, m map[string]pathConfig) {
+		for path, e := range m {
+			if e.Branch == "" {
+				e.Branch = detected[e.web]
+			}
+			e, err := resolvePath(h.host, path, e)
+			if err != nil {
+				log.Fatal(err)
+			}
+			m[path] = e
+		}
+	})
+	for _, h := range hosts {
+		h.mergePaths()
+		h.startDiscovery()
 	}
-	http.HandleFunc("/", handle)
+}
+
+var (
+	// defaultBranch is the branch of paths that don't give one and whose
+	// default branch isn't detected.
+	defaultBranch string
+	// detectBranch is set to detect the default branches of repos, and
+	// branchCache names the file the results are kept in, if any.
+	detectBranch bool
+	branchCache  string
+	// defaultRedirect and defaultRedirectMode are the redirect settings of
+	// paths that don't give their own.
+	defaultRedirect, defaultRedirectMode string
+	// browsers maps the hosts of self-hosted repos to the names of their
+	// source browsers.
+	browsers map[string]string
+)
+
+// expandPathRepo expands the repo of e, and fills in its web URL.
+func expandPathRepo(e pathConfig) pathConfig {
+	e.Repo = codehost.Expand(e.Repo)
+	e.web = e.Repo
+	if web, ssh, ok := codehost.ParseSSH(e.Repo); ok {
+		e.web = web
+		if e.KeepSSH {
+			e.Repo = ssh
+		} else {
+			e.Repo = web
+		}
+	}
+	if clone, home, ok := codeHosts.ParseBitbucketServer(e.web); ok {
+		if e.Repo == e.web {
+			e.Repo = clone
+		}
+		e.web = home
+	}
+	return e
+}
+
+// resolvePath checks the entry e for path on host, after expandPathRepo,
+// and fills in the settings it leaves to the defaults.
+func resolvePath(host, path string, e pathConfig) (pathConfig, error) {
+	if pathPrefix == "" && isHealthPath(path) {
+		return e, fmt.Errorf("%s%s: path is reserved for health checks", host, path)
+	}
+	if e.Proxy && host == "" && !strictHost {
+		// Otherwise the module path would come from the request's Host
+		// header, and anyone could have the server fetch any module.
+		return e, fmt.Errorf("%s: proxy requires host or strict_host to be set", path)
+	}
+	if e.Branch == "" {
+		e.Branch = defaultBranch
+	}
+	switch e.VCS {
+	case "":
+		if codehost.IsLaunchpad(e.web) {
+			e.VCS = "bzr"
+		} else {
+			e.VCS = "git"
+		}
+	case "bzr", "fossil", "git", "hg", "mod", "svn":
+	default:
+		return e, fmt.Errorf("%s%s: unknown VCS %q", host, path, e.VCS)
+	}
+	if e.Redirect == "" {
+		e.Redirect = defaultRedirect
+	}
+	switch e.Redirect {
+	case "":
+		e.Redirect = "docs"
+	case "docs", "repo":
+	default:
+		if u, err := url.Parse(e.Redirect); err != nil || !u.IsAbs() {
+			return e, fmt.Errorf("%s%s: redirect must be docs, repo, or an absolute URL", host, path)
+		}
+	}
+	if err := checkHeaders(e.Headers); err != nil {
+		return e, fmt.Errorf("%s%s: headers: %v", host, path, err)
+	}
+	for _, pkg := range e.Packages {
+		for _, elem := range strings.Split(pkg, "/") {
+			if elem == "" || elem == "." || elem == ".." {
+				return e, fmt.Errorf("%s%s: package %q must be a relative path below the path", host, path, pkg)
+			}
+		}
+	}
+	if e.Added != "" {
+		added, err := time.Parse("2006-01-02", e.Added)
+		if err != nil {
+			return e, fmt.Errorf("%s%s: added must be a date like 2017-01-31", host, path)
+		}
+		e.added = added
+	}
+	if e.RedirectMode == "" {
+		e.RedirectMode = defaultRedirectMode
+	}
+	switch e.RedirectMode {
+	case "":
+		e.RedirectMode = "meta"
+	case "meta", "http":
+	default:
+		return e, fmt.Errorf("%s%s: redirect_mode must be meta or http", host, path)
+	}
+	if e.Browser == "" {
+		if u, err := url.Parse(e.web); err == nil {
+			e.Browser = browsers[u.Host]
+		}
+	}
+	if e.Display == "" {
+		switch {
+		case e.Browser != "":
+			display, err := browserDisplay(e.Browser, e.web, e.BrowserURL, e.Branch)
+			if err != nil {
+				return e, fmt.Errorf("%s%s: %v", host, path, err)
+			}
+			e.Display = display
+		default:
+			e.Display = codeHosts.Display(e.web, e.VCS, e.Branch)
+		}
+	}
+	return e, nil
+}
+
+// pathPrefix is the path, without a trailing slash, under which the app is
+// mounted, or empty if it is served at the root.
+var pathPrefix string
+
+// stripPathPrefix returns a copy of r with pathPrefix removed from its
+// path. It reports false if r's path is outside of pathPrefix.
+func stripPathPrefix(r *http.Request) (*http.Request, bool) {
+	p := strings.TrimPrefix(r.URL.Path, pathPrefix)
+	if len(p) == len(r.URL.Path) || p != "" && p[0] != '/' {
+		return r, false
+	}
+	if p == "" {
+		p = "/"
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	r2.URL.RawPath = ""
+	return r2, true
 }
 
 func handle(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	observed := isObserved(r)
+	r = withRequestID(r)
+	w.Header().Set(requestIDHeader, requestID(r.Context()))
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	defer func() {
+		if !observed {
+			return
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		goGet := r.URL.Query().Get("go-get") == "1"
+		key := rec.key
+		if key == "" {
+			key = unmatchedKey
+		}
+		observeRequest(requestRecord{
+			Request:   r,
+			Start:     start,
+			Path:      rec.path,
+			Subpath:   rec.subpath,
+			Key:       key,
+			Status:    status,
+			Bytes:     rec.bytes,
+			GoGet:     goGet,
+			GoCommand: rec.goCommand || goGet,
+			Duration:  time.Since(start),
+		})
+	}()
+	defer recoverPanic(rec, r)
+	setSecurityHeaders(w)
+	bodyLimit := maxBodyBytes
+	if editHandler != nil && isPathEdit(r.Method, strings.TrimPrefix(r.URL.Path, pathPrefix)) ||
+		adminHandler != nil && r.Method == "POST" && r.URL.Path == adminPrefix {
+		bodyLimit = max(bodyLimit, maxEditBodyBytes)
+	}
+	if isWebhook(r) {
+		bodyLimit = max(bodyLimit, maxWebhookBodyBytes)
+	}
+	if r.ContentLength > bodyLimit {
+		w.Header().Set("Connection", "close")
+		httpError(w, r, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, bodyLimit)
+	if isHealthPath(r.URL.Path) {
+		serveHealth(w, r)
+		return
+	}
+	if debugHandler != nil && strings.HasPrefix(r.URL.Path, debugPrefix) {
+		debugHandler.ServeHTTP(w, r)
+		return
+	}
+	if adminHandler != nil && strings.HasPrefix(r.URL.Path, adminPrefix) {
+		adminHandler.ServeHTTP(w, r)
+		return
+	}
+	if isWebhook(r) {
+		serveWebhook(w, r)
+		return
+	}
+	if metricsHandler != nil && r.URL.Path == metricsPath {
+		metricsHandler.ServeHTTP(w, r)
+		return
+	}
+	if observed && rateLimited(w, r) {
+		return
+	}
+	if inMaintenance() {
+		serveMaintenance(w, r)
+		return
+	}
+	h, host, ok := hostFor(r)
+	if !ok {
+		httpError(w, r, "misdirected request", http.StatusMisdirectedRequest)
+		return
+	}
+	if pathPrefix != "" {
+		r, ok = stripPathPrefix(r)
+		if !ok {
+			notFound(w, r)
+			return
+		}
+		host += pathPrefix
+	}
 	current := r.URL.Path
-	p, ok := m[current]
+	if r.Method == "OPTIONS" && isJSONEndpoint(current) {
+		serveCORSPreflight(w, r)
+		return
+	}
+	if editHandler != nil && isPathEdit(r.Method, current) {
+		editHandler.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if path, file, ok := splitProxyPath(current); ok {
+		if p, ok := h.pathMap()[path]; ok && p.Proxy {
+			modHost, ok := h.proxyHost(r)
+			if !ok {
+				notFound(w, r)
+				return
+			}
+			if p.NoIndex {
+				w.Header().Set("X-Robots-Tag", "noindex")
+			}
+			setHeaders(w, p.Headers)
+			mod := modHost + pathPrefix + path
+			rec.path, rec.subpath, rec.goCommand = mod, file, true
+			rec.key = h.host + path
+			serveProxy(w, r, mod, file)
+			return
+		}
+	}
+	path, subpath, ok := h.findPath(current)
 	if !ok {
-		http.NotFound(w, r)
+		if current == "/" {
+			w.Header().Add("Vary", "Accept")
+		}
+		switch {
+		case current == "/robots.txt":
+			h.serveRobots(w, r, host)
+		case current == "/favicon.ico":
+			serveFavicon(w, r)
+		case strings.HasPrefix(current, badgePrefix):
+			h.serveBadge(w, r, host)
+		case current == versionPath:
+			serveVersion(w, r)
+		case strings.HasPrefix(current, staticPrefix):
+			h.serveStatic(w, r, host)
+		case current == "/" && h.indexRedirect != "":
+			http.Redirect(w, r, h.indexRedirect, http.StatusFound)
+		case h.indexDisabled:
+			h.serveUnmatched(w, r, host)
+		case current == apiPathsPrefix || strings.HasPrefix(current, apiPathsPrefix+"/"):
+			h.serveAPI(w, r, host)
+		case current == "/sitemap.xml":
+			h.serveSitemap(w, r, host)
+		case current != "/" && current != "/index.json":
+			h.serveUnmatched(w, r, host)
+		case current == "/index.json" || wantsJSON(r):
+			h.serveIndexJSON(w, r, host)
+		default:
+			h.serveIndex(w, r, host)
+		}
 		return
 	}
 
-	host := appengine.DefaultVersionHostname(appengine.NewContext(r))
-	if err := vanityTmpl.Execute(w, struct {
-		Import  string
-		Repo    string
-		Display string
+	// The go command only needs the meta tags. Everyone else is sent on to
+	// somewhere more interesting.
+	p := h.pathMap()[path]
+	rec.path, rec.subpath = host+path, subpath
+	rec.key = h.host + path
+	if p.NoIndex {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+	setHeaders(w, p.Headers)
+	pkg := host + path
+	if subpath != "" {
+		pkg += "/" + subpath
+	}
+	// Point search engines at the documentation rather than at this page
+	// and its many near copies.
+	canonical := h.docsURLFor(pkg)
+	w.Header().Set("Link", "<"+canonical+`>; rel="canonical"`)
+	page := vanitypage.Page{
+		Import:    host + path,
+		Subpath:   subpath,
+		VCS:       p.VCS,
+		Repo:      p.Repo,
+		Display:   p.Display,
+		Canonical: canonical,
+		NoIndex:   p.NoIndex,
+		Data:      p.Data,
+	}
+	if query := r.URL.Query(); query.Get("go-get") != "1" {
+		if h.installInstructions {
+			page.Install = &vanitypage.Install{
+				Package:     pkg,
+				Description: p.Description,
+				DocsURL:     canonical,
+				SourceURL:   p.web,
+			}
+		} else {
+			page.Redirect = h.redirectURL(p, host+path, subpath, query)
+		}
+		page.Analytics = h.analytics
+	}
+	if page.Redirect != "" && p.RedirectMode == "http" {
+		http.Redirect(w, r, page.Redirect, http.StatusFound)
+		return
+	}
+	var buf bytes.Buffer
+	if err := h.vanityTmpl.Execute(&buf, page); err != nil {
+		logRenderError(r, "vanity_template", err)
+		httpError(w, r, "cannot render the page", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// writeResponse replies with body, setting its Content-Length so that
+// responses to HEAD requests, which omit the body, carry the same headers
+// as responses to GET. Successful responses carry an ETag computed from
+// body, and conditional requests are answered with 304 Not Modified when
+// possible. Large enough successful responses are gzipped for clients that
+// accept it.
+func writeResponse(w http.ResponseWriter, r *http.Request, code int, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	if code == http.StatusOK {
+		sum := sha256.Sum256(body)
+		etag := hex.EncodeToString(sum[:16])
+		if len(body) >= minGzipSize {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if acceptsGzip(r) {
+				body = gzipBody(etag, body)
+				etag += "-gzip"
+				w.Header().Set("Content-Encoding", "gzip")
+			}
+		}
+		w.Header().Set("ETag", `"`+etag+`"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	if r.Method != "HEAD" {
+		w.Write(body)
+	}
+}
+
+// serveNotFound replies with the not-found template, if one is configured,
+// or a plain 404 otherwise.
+func (h *vanityHost) serveNotFound(w http.ResponseWriter, r *http.Request, host string) {
+	if h.notFoundTmpl == nil {
+		notFound(w, r)
+		return
+	}
+	var buf bytes.Buffer
+	if err := h.notFoundTmpl.Execute(&buf, struct {
+		Host   string
+		Path   string
+		Import string
 	}{
-		Import:  host + current,
-		Repo:    p.Repo,
-		Display: p.Display,
+		Host:   host,
+		Path:   r.URL.Path,
+		Import: host + r.URL.Path,
 	}); err != nil {
-		http.Error(w, "cannot render the page", http.StatusInternalServerError)
+		logRenderError(r, "not_found_template", err)
+		notFound(w, r)
+		return
 	}
+	writeResponse(w, r, http.StatusNotFound, "text/html; charset=utf-8", buf.Bytes())
 }
 
-var vanityTmpl, _ = template.New("vanity").Parse(`<!DOCTYPE html>
-<html>
-<head>
-<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
-<meta name="go-import" content="{{.Import}} git {{.Repo}}">
-<meta name="go-source" content="{{.Import}} {{.Display}}">
-<meta http-equiv="refresh" content="0; url=https://godoc.org/{{.Import}}">
-</head>
-<body>
-Nothing to see here; <a href="https://godoc.org/{{.Import}}">see the package on godoc</a>.
-</body>
-</html>`)
+// serveUnmatched handles a request that no configured path matches by
+// passing it on to the upstream server, if one is configured, or serving
+// the not-found page.
+func (h *vanityHost) serveUnmatched(w http.ResponseWriter, r *http.Request, host string) {
+	if h.upstream != nil {
+		h.upstream.ServeHTTP(w, r)
+		return
+	}
+	h.serveNotFound(w, r, host)
+}
+
+// docsURLFor returns the URL of the documentation for importPath.
+func (h *vanityHost) docsURLFor(importPath string) string {
+	u, err := vanitypage.DocsURL(h.docsURL, importPath)
+	if err != nil {
+		slog.Error("cannot build docs URL", "import", importPath, "err", err)
+	}
+	return u
+}
+
+// findPath returns the configured path that current falls under, along
+// with the rest of current (without a leading slash) as the subpath.
+func (h *vanityHost) findPath(current string) (path, subpath string, ok bool) {
+	paths := h.pathMap()
+	return vanitypage.Find(current, func(path string) bool {
+		_, ok := paths[path]
+		return ok
+	})
+}
+
+// redirectURL returns the URL that browsers visiting subpath of the
+// package at importPath are sent to.
+func (h *vanityHost) redirectURL(p pathConfig, importPath, subpath string, query url.Values) string {
+	return vanitypage.RedirectURL(p.Redirect, p.web, importPath, subpath, query, h.docsURLFor)
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setupHost serves c as the only host for the rest of the test, with its
+// paths resolved as if read from the config. The global state that it
+// and the handlers under test change is restored when the test ends.
+func setupHost(t *testing.T, c *hostConfig) *vanityHost {
+	t.Helper()
+	oldHosts, oldPathsFile, oldBranch, oldLimiter := hosts, pathsFile, defaultBranch, limiter
+	t.Cleanup(func() {
+		hosts, pathsFile, defaultBranch, limiter = oldHosts, oldPathsFile, oldBranch, oldLimiter
+	})
+	defaultBranch = "master"
+	for path, e := range c.Paths {
+		e, err := resolvePath(c.Host, path, expandPathRepo(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Paths[path] = e
+	}
+	h, err := newVanityHost(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.mergePaths()
+	hosts = []*vanityHost{h}
+	return h
+}
+
+// sendRequest sends serve a request for target, a path on go.example.com
+// with an optional query, and returns the response.
+func sendRequest(serve http.HandlerFunc, method, target string, header http.Header, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "http://go.example.com"+target, strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	serve(w, r)
+	return w
+}
+
+func TestFindPath(t *testing.T) {
+	h := &vanityHost{
+		paths: map[string]pathConfig{
+			"/portmidi":   {Repo: "https://github.com/rakyll/portmidi"},
+			"/launchpad":  {Repo: "https://github.com/rakyll/launchpad"},
+			"/nested/mod": {Repo: "https://github.com/rakyll/nested"},
+		},
+	}
+	tests := []struct {
+		current string
+		path    string
+		subpath string
+		ok      bool
+	}{
+		{"/portmidi", "/portmidi", "", true},
+		{"/portmidi/", "/portmidi", "", true},
+		{"/portmidi/sub/pkg", "/portmidi", "sub/pkg", true},
+		{"/nested/mod/pkg", "/nested/mod", "pkg", true},
+		{"/nested", "", "", false},
+		{"/portmidix", "", "", false},
+		{"/", "", "", false},
+		{"", "", "", false},
+		// Requests like "OPTIONS * HTTP/1.1" have paths that don't start
+		// with a slash.
+		{"*", "", "", false},
+		{"portmidi", "", "", false},
+		{"portmidi/sub", "", "", false},
+	}
+	for _, test := range tests {
+		path, subpath, ok := h.findPath(test.current)
+		if path != test.path || subpath != test.subpath || ok != test.ok {
+			t.Errorf("findPath(%q) = %q, %q, %t; want %q, %q, %t", test.current, path, subpath, ok, test.path, test.subpath, test.ok)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// maintenance is set while the server is in maintenance mode.
+	maintenance atomic.Bool
+	// maintenanceFile, if it exists, also puts the server in maintenance
+	// mode.
+	maintenanceFile string
+	// maintenanceRetryAfter is how long clients are told to wait before
+	// trying again during maintenance.
+	maintenanceRetryAfter = 5 * time.Minute
+)
+
+// adminPrefix is the path under which the endpoints that change the
+// server at runtime are served.
+const adminPrefix = "/-/admin/"
+
+// adminHandler serves the admin endpoints, if any are turned on.
+var adminHandler http.Handler
+
+// inMaintenance reports whether the server is in maintenance mode.
+func inMaintenance() bool {
+	if maintenance.Load() {
+		return true
+	}
+	if maintenanceFile == "" {
+		return false
+	}
+	_, err := os.Stat(maintenanceFile)
+	return err == nil
+}
+
+// serveMaintenance replies with a 503 telling the client to come back
+// later. The go command prints the body of an error, so it is kept short.
+func serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(maintenanceRetryAfter.Seconds())))
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("go-get") == "1" || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		httpError(w, r, "down for maintenance; try again later", http.StatusServiceUnavailable)
+		return
+	}
+	var buf bytes.Buffer
+	if err := maintenanceTmpl.Execute(&buf, nil); err != nil {
+		logRenderError(r, "maintenance", err)
+		httpError(w, r, "down for maintenance; try again later", http.StatusServiceUnavailable)
+		return
+	}
+	writeResponse(w, r, http.StatusServiceUnavailable, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// serveMaintenanceToggle serves /-/admin/maintenance. GET reports whether
+// the server is in maintenance mode, and POST with on=true or on=false
+// turns it on or off.
+func serveMaintenanceToggle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "POST":
+		on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, "on must be true or false")
+			return
+		}
+		maintenance.Store(on)
+		if on {
+			recordAudit(newAuditEvent(r, "maintenance_on"))
+		} else {
+			recordAudit(newAuditEvent(r, "maintenance_off"))
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeJSONError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, struct {
+		Maintenance bool `json:"maintenance"`
+	}{inMaintenance()})
+}
+
+var maintenanceTmpl = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Down for maintenance</title>
+</head>
+<body>
+<h1>Down for maintenance</h1>
+<p>This site is being worked on. Please try again in a few minutes.</p>
+</body>
+</html>
+`))
@@ -0,0 +1,89 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newMetricsHandler returns a handler for the Prometheus metrics of the
+// server and starts collecting them.
+func newMetricsHandler() http.Handler {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "govanityurls_requests_total",
+		Help: "Requests served, by the configured host and path they were for (\"(unmatched)\" if none), status, and client.",
+	}, []string{"path", "status", "client"})
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "govanityurls_request_duration_seconds",
+		Help:    "How long requests took to serve, by client.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"client"})
+	configLoads := prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "govanityurls_config_loads_total",
+		Help:        "Times the config was loaded, by result.",
+		ConstLabels: prometheus.Labels{"result": "success"},
+	}, func() float64 {
+		return float64(stats.configLoads.Load())
+	})
+	configLoadFailures := prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "govanityurls_config_loads_total",
+		Help:        "Times the config was loaded, by result.",
+		ConstLabels: prometheus.Labels{"result": "failure"},
+	}, func() float64 {
+		return float64(stats.configLoadFailures.Load())
+	})
+	configLoadTime := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "govanityurls_config_last_load_timestamp_seconds",
+		Help: "When the config was last loaded, in seconds since the epoch.",
+	}, func() float64 {
+		return float64(loadTime.UnixNano()) / 1e9
+	})
+	configReloadTime := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "govanityurls_config_last_reload_success_timestamp_seconds",
+		Help: "When the config was last reloaded successfully, in seconds since the epoch, or 0 if it hasn't been since the server started.",
+	}, func() float64 {
+		if reloadedAt.IsZero() {
+			return 0
+		}
+		return float64(reloadedAt.UnixNano()) / 1e9
+	})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		durations,
+		configLoads,
+		configLoadFailures,
+		configLoadTime,
+		configReloadTime,
+	)
+	requestObservers = append(requestObservers, func(rr requestRecord) {
+		client := "browser"
+		if rr.GoCommand {
+			client = "go"
+		}
+		requests.WithLabelValues(rr.Key, strconv.Itoa(rr.Status), client).Inc()
+		durations.WithLabelValues(client).Observe(rr.Duration.Seconds())
+	})
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,297 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcClientSecretEnv holds the client secret, if it isn't in the config.
+const oidcClientSecretEnv = "GOVANITYURLS_OIDC_CLIENT_SECRET"
+
+const (
+	// oidcSessionCookie holds the session of a logged-in browser, and
+	// oidcStateCookie the state of a login in progress.
+	oidcSessionCookie = "govanityurls_session"
+	oidcStateCookie   = "govanityurls_login"
+	// oidcSessionTTL is how long a login lasts.
+	oidcSessionTTL = 12 * time.Hour
+	// oidcLoginTTL is how long a browser has to log in with the provider.
+	oidcLoginTTL = 10 * time.Minute
+)
+
+// oidcLogin logs browsers in to the management endpoints with an OpenID
+// Connect provider, letting in the members of the allowed groups.
+type oidcLogin struct {
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	groups   map[string]bool
+	claim    string
+	// key signs the cookies. It is derived from the client secret, so
+	// that sessions outlive upgrades.
+	key []byte
+}
+
+// newOIDCLogin discovers the provider described by c.
+func newOIDCLogin(ctx context.Context, c oidcConfig) (*oidcLogin, error) {
+	if c.ClientSecret == "" {
+		c.ClientSecret = os.Getenv(oidcClientSecretEnv)
+	}
+	switch {
+	case c.ClientID == "" || c.ClientSecret == "":
+		return nil, errors.New("client_id and client_secret are required")
+	case c.RedirectURL == "":
+		return nil, fmt.Errorf("redirect_url is required, like https://example.com%scallback", loginPrefix)
+	case len(c.AllowedGroups) == 0:
+		// Otherwise anyone with an account at the provider could log in.
+		return nil, errors.New("allowed_groups is required")
+	}
+	provider, err := oidc.NewProvider(ctx, c.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	l := &oidcLogin{
+		config: oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  c.RedirectURL,
+			Scopes:       append([]string{oidc.ScopeOpenID, "email", "profile"}, c.Scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: c.ClientID}),
+		groups:   make(map[string]bool),
+		claim:    c.GroupsClaim,
+	}
+	if l.claim == "" {
+		l.claim = "groups"
+	}
+	for _, g := range c.AllowedGroups {
+		l.groups[g] = true
+	}
+	mac := hmac.New(sha256.New, []byte(c.ClientSecret))
+	mac.Write([]byte("govanityurls session key"))
+	l.key = mac.Sum(nil)
+	return l, nil
+}
+
+// oidcSession is kept in the session cookie.
+type oidcSession struct {
+	User    string `json:"user"`
+	Expires int64  `json:"exp"`
+}
+
+// oidcState is kept in the state cookie during a login.
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+	Expires  int64  `json:"exp"`
+}
+
+func (l *oidcLogin) user(r *http.Request) string {
+	var s oidcSession
+	if !l.readCookie(r, oidcSessionCookie, &s) || time.Now().Unix() >= s.Expires {
+		return ""
+	}
+	return s.User
+}
+
+func (l *oidcLogin) start(w http.ResponseWriter, r *http.Request) {
+	st := oidcState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: oauth2.GenerateVerifier(),
+		Next:     r.URL.RequestURI(),
+		Expires:  time.Now().Add(oidcLoginTTL).Unix(),
+	}
+	l.setCookie(w, r, oidcStateCookie, st, oidcLoginTTL)
+	w.Header().Set("Cache-Control", "no-store")
+	u := l.config.AuthCodeURL(st.State, oidc.Nonce(st.Nonce), oauth2.S256ChallengeOption(st.Verifier))
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// ServeHTTP serves the callback the provider sends browsers back to, and
+// the logout endpoint.
+func (l *oidcLogin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.URL.Path {
+	case loginPrefix + "callback":
+		l.callback(w, r)
+	case loginPrefix + "logout":
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if crossSite(r) {
+			httpError(w, r, "cross-site request refused", http.StatusForbidden)
+			return
+		}
+		l.setCookie(w, r, oidcSessionCookie, nil, -1)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		notFound(w, r)
+	}
+}
+
+func (l *oidcLogin) callback(w http.ResponseWriter, r *http.Request) {
+	var st oidcState
+	q := r.URL.Query()
+	if !l.readCookie(r, oidcStateCookie, &st) || time.Now().Unix() >= st.Expires || q.Get("state") != st.State {
+		httpError(w, r, "login expired; try again", http.StatusBadRequest)
+		return
+	}
+	l.setCookie(w, r, oidcStateCookie, nil, -1)
+	if e := q.Get("error"); e != "" {
+		httpError(w, r, "login failed: "+e, http.StatusForbidden)
+		return
+	}
+	tok, err := l.config.Exchange(r.Context(), q.Get("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		slog.WarnContext(r.Context(), "OIDC code exchange failed", "err", err)
+		httpError(w, r, "login failed", http.StatusForbidden)
+		return
+	}
+	raw, _ := tok.Extra("id_token").(string)
+	idToken, err := l.verifier.Verify(r.Context(), raw)
+	if err != nil || idToken.Nonce != st.Nonce {
+		slog.WarnContext(r.Context(), "invalid OIDC ID token", "err", err)
+		httpError(w, r, "login failed", http.StatusForbidden)
+		return
+	}
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		httpError(w, r, "login failed", http.StatusForbidden)
+		return
+	}
+	// Go by the email address only if the provider says it checked it,
+	// since some let users give any address they like. Providers that
+	// don't say are identified by the subject alone.
+	user := idToken.Subject
+	if email, ok := claims["email"].(string); ok && email != "" {
+		switch verified, ok := claims["email_verified"].(bool); {
+		case ok && verified:
+			user = email
+		case ok:
+			slog.WarnContext(r.Context(), "OIDC login refused: email address not verified", "sub", idToken.Subject, "email", email)
+			httpError(w, r, "login failed: email address not verified", http.StatusForbidden)
+			return
+		}
+	}
+	if !l.allowed(claims[l.claim]) {
+		slog.WarnContext(r.Context(), "OIDC login refused", "user", user)
+		httpError(w, r, "you are not in a group allowed to log in", http.StatusForbidden)
+		return
+	}
+	slog.InfoContext(r.Context(), "OIDC login", "user", user)
+	l.setCookie(w, r, oidcSessionCookie, oidcSession{
+		User:    user,
+		Expires: time.Now().Add(oidcSessionTTL).Unix(),
+	}, oidcSessionTTL)
+	next := st.Next
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = adminPrefix
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// allowed reports whether groups, the value of the groups claim, names
+// any of the allowed groups.
+func (l *oidcLogin) allowed(groups interface{}) bool {
+	switch groups := groups.(type) {
+	case string:
+		return l.groups[groups]
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok && l.groups[s] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setCookie sets the cookie name to v, signed, for ttl. A negative ttl
+// deletes the cookie.
+func (l *oidcLogin) setCookie(w http.ResponseWriter, r *http.Request, name string, v interface{}, ttl time.Duration) {
+	c := &http.Cookie{
+		Name:     name,
+		Path:     "/-/",
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   r.TLS != nil || strings.HasPrefix(l.config.RedirectURL, "https:"),
+		HttpOnly: true,
+		// Lax, so that the cookies come back from the provider's redirect.
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ttl >= 0 {
+		data, _ := json.Marshal(v)
+		payload := base64.RawURLEncoding.EncodeToString(data)
+		c.Value = payload + "." + base64.RawURLEncoding.EncodeToString(l.sign(name, payload))
+	} else {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// readCookie reads the signed cookie name into v.
+func (l *oidcLogin) readCookie(r *http.Request, name string, v interface{}) bool {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+	payload, sig, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, l.sign(name, payload)) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+// sign signs payload for the cookie name, so that one cookie can't stand
+// in for another.
+func (l *oidcLogin) sign(name, payload string) []byte {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte(name + "=" + payload))
+	return mac.Sum(nil)
+}
+
+// randomString returns a random string for use as a state or nonce.
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,270 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeIssuer is an OpenID Connect provider that issues ID tokens with the
+// claims the test sets.
+type fakeIssuer struct {
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &fakeIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                iss.srv.URL,
+			"authorization_endpoint":                iss.srv.URL + "/auth",
+			"token_endpoint":                        iss.srv.URL + "/token",
+			"jwks_uri":                              iss.srv.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     iss.sign(t, iss.claims),
+		})
+	})
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+	return iss
+}
+
+// sign returns an ID token with claims, signed with RS256.
+func (iss *fakeIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Error(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Error(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestOIDCLogin(t *testing.T, iss *fakeIssuer) *oidcLogin {
+	t.Helper()
+	l, err := newOIDCLogin(context.Background(), oidcConfig{
+		Issuer:        iss.srv.URL,
+		ClientID:      "govanityurls",
+		ClientSecret:  "s3cret",
+		RedirectURL:   "http://go.example.com" + loginPrefix + "callback",
+		AllowedGroups: []string{"admins"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// cookieHeader returns the Cookie header that sends back the cookies set
+// in w.
+func cookieHeader(w *httptest.ResponseRecorder) http.Header {
+	var pairs []string
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			pairs = append(pairs, c.Name+"="+c.Value)
+		}
+	}
+	return http.Header{"Cookie": {strings.Join(pairs, "; ")}}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	iss := newFakeIssuer(t)
+	l := newTestOIDCLogin(t, iss)
+	tests := []struct {
+		name string
+		// edit changes the claims of the ID token, the state sent back,
+		// or both.
+		edit func(claims map[string]interface{}, q url.Values)
+		want int
+		user string
+	}{
+		{
+			name: "ok",
+			edit: func(map[string]interface{}, url.Values) {},
+			want: http.StatusFound,
+			user: "rakyll@example.com",
+		},
+		{
+			name: "groups as a string",
+			edit: func(claims map[string]interface{}, q url.Values) { claims["groups"] = "admins" },
+			want: http.StatusFound,
+			user: "rakyll@example.com",
+		},
+		{
+			name: "no email_verified",
+			edit: func(claims map[string]interface{}, q url.Values) { delete(claims, "email_verified") },
+			want: http.StatusFound,
+			user: "user-1",
+		},
+		{
+			name: "email not verified",
+			edit: func(claims map[string]interface{}, q url.Values) { claims["email_verified"] = false },
+			want: http.StatusForbidden,
+		},
+		{
+			name: "not in an allowed group",
+			edit: func(claims map[string]interface{}, q url.Values) { claims["groups"] = []string{"users"} },
+			want: http.StatusForbidden,
+		},
+		{
+			name: "no groups",
+			edit: func(claims map[string]interface{}, q url.Values) { delete(claims, "groups") },
+			want: http.StatusForbidden,
+		},
+		{
+			name: "nonce mismatch",
+			edit: func(claims map[string]interface{}, q url.Values) { claims["nonce"] = "other" },
+			want: http.StatusForbidden,
+		},
+		{
+			name: "state mismatch",
+			edit: func(claims map[string]interface{}, q url.Values) { q.Set("state", "other") },
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "wrong audience",
+			edit: func(claims map[string]interface{}, q url.Values) { claims["aud"] = "other" },
+			want: http.StatusForbidden,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := sendRequest(l.start, "GET", adminPrefix+"status", nil, "")
+			if start.Code != http.StatusFound {
+				t.Fatalf("start = %d; want %d", start.Code, http.StatusFound)
+			}
+			auth, err := url.Parse(start.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			iss.claims = map[string]interface{}{
+				"iss":            iss.srv.URL,
+				"aud":            "govanityurls",
+				"sub":            "user-1",
+				"iat":            time.Now().Unix(),
+				"exp":            time.Now().Add(time.Hour).Unix(),
+				"nonce":          auth.Query().Get("nonce"),
+				"email":          "rakyll@example.com",
+				"email_verified": true,
+				"groups":         []string{"users", "admins"},
+			}
+			q := url.Values{"state": {auth.Query().Get("state")}, "code": {"code"}}
+			test.edit(iss.claims, q)
+
+			callback := sendRequest(l.ServeHTTP, "GET", loginPrefix+"callback?"+q.Encode(), cookieHeader(start), "")
+			if callback.Code != test.want {
+				t.Fatalf("callback = %d; want %d\n%s", callback.Code, test.want, callback.Body)
+			}
+			r := httptest.NewRequest("GET", "http://go.example.com"+adminPrefix, nil)
+			r.Header = cookieHeader(callback)
+			if user := l.user(r); user != test.user {
+				t.Errorf("logged in as %q; want %q", user, test.user)
+			}
+			if test.want == http.StatusFound {
+				if loc := callback.Header().Get("Location"); loc != adminPrefix+"status" {
+					t.Errorf("callback sent the browser to %q; want %q", loc, adminPrefix+"status")
+				}
+			}
+		})
+	}
+}
+
+func TestOIDCCookies(t *testing.T) {
+	l := &oidcLogin{key: []byte("key")}
+	w := httptest.NewRecorder()
+	want := oidcSession{User: "rakyll@example.com", Expires: 1}
+	l.setCookie(w, httptest.NewRequest("GET", "/", nil), oidcSessionCookie, want, time.Hour)
+	value := w.Result().Cookies()[0].Value
+	payload, sig, _ := strings.Cut(value, ".")
+	forged, _ := json.Marshal(oidcSession{User: "root", Expires: 1})
+
+	tests := []struct {
+		name   string
+		cookie string
+		ok     bool
+	}{
+		{"round trip", oidcSessionCookie + "=" + value, true},
+		{"tampered payload", oidcSessionCookie + "=" + base64.RawURLEncoding.EncodeToString(forged) + "." + sig, false},
+		{"tampered signature", oidcSessionCookie + "=" + payload + "." + base64.RawURLEncoding.EncodeToString([]byte("sig")), false},
+		{"no signature", oidcSessionCookie + "=" + payload, false},
+		{"signed for another cookie", oidcStateCookie + "=" + value, false},
+		{"other key", oidcSessionCookie + "=" + value, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Cookie", test.cookie)
+		name := oidcSessionCookie
+		if strings.HasPrefix(test.cookie, oidcStateCookie) {
+			name = oidcStateCookie
+		}
+		rl := l
+		if test.name == "other key" {
+			rl = &oidcLogin{key: []byte("other")}
+		}
+		var got oidcSession
+		ok := rl.readCookie(r, name, &got)
+		if ok != test.ok || ok && got != want {
+			t.Errorf("%s: readCookie = %+v, %t; want %+v, %t", test.name, got, ok, want, test.ok)
+		}
+	}
+}
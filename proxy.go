@@ -0,0 +1,358 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+const (
+	// maxProxyCommands bounds the number of go commands run at once for
+	// the module proxy. Requests beyond it wait their turn.
+	maxProxyCommands = 4
+	// maxProxyCacheEntries bounds the number of results of go commands
+	// kept.
+	maxProxyCacheEntries = 1024
+	// proxyListTTL is how long lists of versions and the latest version
+	// are reused, and proxyErrorTTL how long failures are. A version,
+	// once downloaded, never changes, so it is kept for proxyDownloadTTL.
+	proxyListTTL     = time.Minute
+	proxyErrorTTL    = time.Minute
+	proxyDownloadTTL = 24 * time.Hour
+)
+
+// proxySem holds a token for each go command running for the module
+// proxy.
+var proxySem = make(chan struct{}, maxProxyCommands)
+
+// proxyCache holds the results of recent go commands run for the module
+// proxy, keyed by their arguments, so that repeated requests don't each
+// start one. Commands still running are in pending, so that requests
+// arriving meanwhile wait for them rather than starting the same one.
+var proxyCache struct {
+	mu      sync.Mutex
+	m       map[string]proxyResult
+	pending map[string]*proxyCall
+}
+
+// proxyResult is the output of a go command, or its failure.
+type proxyResult struct {
+	out     []byte
+	err     error
+	expires time.Time
+}
+
+// proxyCall is a go command being run for the module proxy. Its result is
+// set before done is closed, and ok is false if the request that ran it
+// gave up first.
+type proxyCall struct {
+	done chan struct{}
+	res  proxyResult
+	ok   bool
+}
+
+// splitProxyPath splits a module proxy request path like
+// "/portmidi/@v/v1.0.0.info" into the vanity path ("/portmidi") and the
+// proxy file ("v1.0.0.info" or "@latest"). The vanity path is returned
+// with the proxy protocol's case encoding removed.
+func splitProxyPath(p string) (vanity, file string, ok bool) {
+	if strings.HasSuffix(p, "/@latest") {
+		vanity, file = strings.TrimSuffix(p, "/@latest"), "@latest"
+	} else if i := strings.LastIndex(p, "/@v/"); i >= 0 {
+		vanity, file = p[:i], p[i+len("/@v/"):]
+	} else {
+		return "", "", false
+	}
+	vanity, ok = unescapeModulePath(vanity)
+	if !ok || vanity == "" || file == "" {
+		return "", "", false
+	}
+	return vanity, file, true
+}
+
+// unescapeModulePath reverses the proxy protocol's case encoding, in which
+// each upper-case letter is written as '!' followed by its lower-case form.
+func unescapeModulePath(p string) (string, bool) {
+	var sb strings.Builder
+	bang := false
+	for _, c := range p {
+		switch {
+		case bang:
+			if c < 'a' || c > 'z' {
+				return "", false
+			}
+			sb.WriteRune(c - 'a' + 'A')
+			bang = false
+		case c == '!':
+			bang = true
+		case 'A' <= c && c <= 'Z':
+			return "", false
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	if bang {
+		return "", false
+	}
+	return sb.String(), true
+}
+
+// proxyHost returns the host name that the modules of h are proxied
+// under. It comes from the config rather than from the request, so that
+// clients can't have the server fetch modules of their choosing by sending
+// some other Host header. It reports false if the config names no host for
+// r.
+func (h *vanityHost) proxyHost(r *http.Request) (string, bool) {
+	if h.host != "" {
+		return h.host, true
+	}
+	if strictHost {
+		for _, host := range allowedHosts {
+			if matchHost(host, r) {
+				return host, true
+			}
+		}
+	}
+	return "", false
+}
+
+// serveProxy serves a single file of the module proxy protocol for the
+// module mod. It shells out to the go command, which fetches directly from
+// the module's VCS (by way of this server's go-import tags) and caches the
+// results in the module cache. Failures are logged, but clients are only
+// told that the module or version wasn't found.
+func serveProxy(w http.ResponseWriter, r *http.Request, mod, file string) {
+	fail := func(err error) {
+		slog.WarnContext(r.Context(), "cannot proxy module", "module", mod, "file", file, "err", err)
+		httpError(w, r, "not found: "+mod, http.StatusNotFound)
+	}
+	switch file {
+	case "list":
+		var list struct {
+			Versions []string
+		}
+		if err := goJSON(r.Context(), proxyListTTL, &list, "list", "-m", "-versions", "-json", mod); err != nil {
+			fail(err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, v := range list.Versions {
+			fmt.Fprintln(w, v)
+		}
+	case "@latest":
+		var info struct {
+			Version string
+			Time    *time.Time `json:",omitempty"`
+		}
+		if err := goJSON(r.Context(), proxyListTTL, &info, "list", "-m", "-json", mod+"@latest"); err != nil {
+			fail(err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	default:
+		ext := path.Ext(file)
+		// Versions are case-encoded like module paths.
+		version, ok := unescapeModulePath(strings.TrimSuffix(file, ext))
+		if ext != ".info" && ext != ".mod" && ext != ".zip" || !ok || version == "" {
+			notFound(w, r)
+			return
+		}
+		// Like proxy.golang.org, only serve canonical versions. A query like
+		// "master" would otherwise be resolved once and served from the cache
+		// for as long as a download is, long after the branch moved on.
+		if module.CanonicalVersion(version) != version {
+			httpError(w, r, "not found: "+mod+"@"+version+": version is not canonical", http.StatusNotFound)
+			return
+		}
+		var dl struct {
+			Info  string
+			GoMod string
+			Zip   string
+		}
+		if err := goJSON(r.Context(), proxyDownloadTTL, &dl, "mod", "download", "-json", mod+"@"+version); err != nil {
+			fail(err)
+			return
+		}
+		switch ext {
+		case ".info":
+			w.Header().Set("Content-Type", "application/json")
+			http.ServeFile(w, r, dl.Info)
+		case ".mod":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			http.ServeFile(w, r, dl.GoMod)
+		case ".zip":
+			w.Header().Set("Content-Type", "application/zip")
+			http.ServeFile(w, r, dl.Zip)
+		}
+	}
+}
+
+// goJSON runs the go command with args outside of any module and decodes
+// its JSON output into v. The output is reused for ttl, and failures for
+// proxyErrorTTL. No more than maxProxyCommands commands run at once, and
+// requests for the same command share one.
+func goJSON(ctx context.Context, ttl time.Duration, v interface{}, args ...string) error {
+	res, err := proxyResultFor(ctx, ttl, args)
+	if err != nil {
+		return err
+	}
+	if res.err != nil {
+		return res.err
+	}
+	return json.Unmarshal(res.out, v)
+}
+
+// proxyResultFor returns the cached result of the go command with args, or
+// runs it if there is none. If the command is already running for another
+// request, it waits for that result instead.
+func proxyResultFor(ctx context.Context, ttl time.Duration, args []string) (proxyResult, error) {
+	key := strings.Join(args, " ")
+	for {
+		now := time.Now()
+		proxyCache.mu.Lock()
+		if res, ok := proxyCache.m[key]; ok && !now.After(res.expires) {
+			proxyCache.mu.Unlock()
+			return res, nil
+		}
+		if c, ok := proxyCache.pending[key]; ok {
+			proxyCache.mu.Unlock()
+			select {
+			case <-c.done:
+			case <-ctx.Done():
+				return proxyResult{}, ctx.Err()
+			}
+			if c.ok {
+				return c.res, nil
+			}
+			// The request running the command gave up on it, so run it
+			// again for this one.
+			continue
+		}
+		if proxyCache.pending == nil {
+			proxyCache.pending = make(map[string]*proxyCall)
+		}
+		c := &proxyCall{done: make(chan struct{})}
+		proxyCache.pending[key] = c
+		proxyCache.mu.Unlock()
+
+		c.res, c.ok = runProxyCommand(ctx, ttl, now, args)
+		if c.ok {
+			storeProxyResult(key, c.res, now)
+		}
+		proxyCache.mu.Lock()
+		delete(proxyCache.pending, key)
+		proxyCache.mu.Unlock()
+		close(c.done)
+		if !c.ok {
+			return proxyResult{}, ctx.Err()
+		}
+		return c.res, nil
+	}
+}
+
+// runProxyCommand runs the go command with args once fewer than
+// maxProxyCommands are running, and returns its result, to be reused until
+// ttl after now. It reports false if ctx is done first, since a client
+// giving up isn't a result worth remembering.
+func runProxyCommand(ctx context.Context, ttl time.Duration, now time.Time, args []string) (proxyResult, bool) {
+	select {
+	case proxySem <- struct{}{}:
+	case <-ctx.Done():
+		return proxyResult{}, false
+	}
+	var res proxyResult
+	res.out, res.err = runGo(ctx, args...)
+	<-proxySem
+	if ctx.Err() != nil {
+		return proxyResult{}, false
+	}
+	res.expires = now.Add(ttl)
+	if res.err != nil {
+		res.expires = now.Add(proxyErrorTTL)
+	}
+	return res, true
+}
+
+// storeProxyResult adds res to the cache as the result for key, making
+// room by dropping expired results if need be.
+func storeProxyResult(key string, res proxyResult, now time.Time) {
+	proxyCache.mu.Lock()
+	defer proxyCache.mu.Unlock()
+	if proxyCache.m == nil {
+		proxyCache.m = make(map[string]proxyResult)
+	}
+	if len(proxyCache.m) >= maxProxyCacheEntries {
+		for k, old := range proxyCache.m {
+			if now.After(old.expires) {
+				delete(proxyCache.m, k)
+			}
+		}
+	}
+	if len(proxyCache.m) < maxProxyCacheEntries {
+		proxyCache.m[key] = res
+	}
+}
+
+// runGo runs the go command with args in a new, empty directory, so that
+// no go.mod or go.work file around it changes what it does, and returns
+// its output.
+func runGo(ctx context.Context, args ...string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "govanityurls-proxy-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GO111MODULE=on",
+		"GOWORK=off",
+		"GOFLAGS=-mod=mod",
+		// Fetch straight from the VCS. Clients verify what we serve against
+		// the checksum database themselves.
+		"GOPROXY=direct",
+		"GOSUMDB=off",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	// go mod download reports failures in the Error field of its output.
+	var e struct{ Error string }
+	if json.Unmarshal(out, &e) == nil && e.Error != "" {
+		return nil, errors.New(e.Error)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSplitProxyPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		vanity string
+		file   string
+		ok     bool
+	}{
+		{"/portmidi/@v/list", "/portmidi", "list", true},
+		{"/portmidi/@v/v1.0.0.info", "/portmidi", "v1.0.0.info", true},
+		{"/portmidi/@latest", "/portmidi", "@latest", true},
+		{"/nested/mod/@v/v1.0.0.zip", "/nested/mod", "v1.0.0.zip", true},
+		// Upper-case letters are escaped, but the file isn't unescaped
+		// until its version is looked at.
+		{"/!port!midi/@v/v1.0.0-!r!c1.mod", "/PortMidi", "v1.0.0-!r!c1.mod", true},
+		{"/!port!midi/@latest", "/PortMidi", "@latest", true},
+		{"/PortMidi/@v/list", "", "", false},
+		{"/port!/@v/list", "", "", false},
+		{"/port!!midi/@v/list", "", "", false},
+		{"/port!1midi/@v/list", "", "", false},
+		{"/portmidi/@v/", "", "", false},
+		{"/@latest", "", "", false},
+		{"/@v/list", "", "", false},
+		{"/portmidi", "", "", false},
+		{"/portmidi/@v", "", "", false},
+		{"/portmidi/latest", "", "", false},
+		{"", "", "", false},
+	}
+	for _, test := range tests {
+		vanity, file, ok := splitProxyPath(test.path)
+		if vanity != test.vanity || file != test.file || ok != test.ok {
+			t.Errorf("splitProxyPath(%q) = %q, %q, %t; want %q, %q, %t", test.path, vanity, file, ok, test.vanity, test.file, test.ok)
+		}
+	}
+}
+
+func TestUnescapeModulePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"go.example.com/portmidi", "go.example.com/portmidi", true},
+		{"github.com/!azure/azure-sdk-for-go", "github.com/Azure/azure-sdk-for-go", true},
+		{"!b!u!r!n!t!s!u!s!h!i", "BURNTSUSHI", true},
+		{"v1.0.0-!r!c1", "v1.0.0-RC1", true},
+		{"go.example.com/héllo", "go.example.com/héllo", true},
+		{"", "", true},
+		{"github.com/Azure/azure-sdk-for-go", "", false},
+		{"github.com/!", "", false},
+		{"github.com/!!azure", "", false},
+		{"github.com/!1", "", false},
+		{"github.com/!-", "", false},
+		{"github.com/!é", "", false},
+	}
+	for _, test := range tests {
+		got, ok := unescapeModulePath(test.path)
+		if got != test.want || ok != test.ok {
+			t.Errorf("unescapeModulePath(%q) = %q, %t; want %q, %t", test.path, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestGoJSONSharesCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake go command is a shell script")
+	}
+	// Stand in for the go command with one that is slow enough for every
+	// request to arrive while it runs, and that counts how often it does.
+	dir := t.TempDir()
+	count := filepath.Join(dir, "count")
+	script := "#!/bin/sh\necho run >> \"$FAKE_GO_COUNT\"\nsleep 0.2\necho '{\"Version\": \"v1.0.0\"}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "go"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_GO_COUNT", count)
+	t.Cleanup(func() {
+		proxyCache.mu.Lock()
+		proxyCache.m = nil
+		proxyCache.mu.Unlock()
+	})
+
+	const n = 2 * maxProxyCommands
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	versions := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var info struct{ Version string }
+			errs[i] = goJSON(context.Background(), time.Minute, &info, "list", "-m", "-json", "go.example.com/portmidi@latest")
+			versions[i] = info.Version
+		}(i)
+	}
+	wg.Wait()
+	for i := range errs {
+		if errs[i] != nil || versions[i] != "v1.0.0" {
+			t.Errorf("request %d: goJSON = %q, %v; want v1.0.0, <nil>", i, versions[i], errs[i])
+		}
+	}
+	out, err := os.ReadFile(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(out), "run"); runs != 1 {
+		t.Errorf("go command ran %d times for %d requests; want 1", runs, n)
+	}
+	proxyCache.mu.Lock()
+	pending := len(proxyCache.pending)
+	proxyCache.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d commands still pending after all requests finished; want 0", pending)
+	}
+}
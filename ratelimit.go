@@ -0,0 +1,115 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// limiter limits the rate of requests from each client, if rate limiting
+// is turned on.
+var limiter *rateLimiter
+
+// rateLimiter is a token bucket for each client IP address.
+type rateLimiter struct {
+	rate  float64 // tokens added per second
+	burst float64 // most tokens a bucket holds
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing each client rate requests a
+// second on average, and up to burst at once.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow reports whether the client at ip may make a request at now,
+// taking a token if so. If not, it returns how long until the client may.
+func (l *rateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b := l.buckets[ip]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets the clients whose buckets have filled up again, at most
+// once a minute, so that the map doesn't grow without bound.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// rateLimited reports whether the client of r has made too many requests,
+// replying with 429 Too Many Requests if so.
+func rateLimited(w http.ResponseWriter, r *http.Request) bool {
+	if limiter == nil {
+		return false
+	}
+	ok, wait := limiter.allow(clientIP(r), time.Now())
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	httpError(w, r, "too many requests", http.StatusTooManyRequests)
+	return true
+}
+
+// limitRate returns a handler that passes requests on to h unless their
+// client has made too many. It wraps the endpoints under adminPrefix and
+// debugPrefix, which handle serves before its own check, so that their
+// credentials can't be guessed at full speed.
+func limitRate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimited(w, r) {
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	start := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ip    string
+		after time.Duration
+		ok    bool
+		wait  time.Duration
+	}{
+		// The burst is allowed at once.
+		{"192.0.2.1", 0, true, 0},
+		{"192.0.2.1", 0, true, 0},
+		{"192.0.2.1", 0, true, 0},
+		{"192.0.2.1", 0, false, 500 * time.Millisecond},
+		// Other clients have buckets of their own.
+		{"192.0.2.2", 0, true, 0},
+		// Tokens come back at the rate.
+		{"192.0.2.1", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"192.0.2.1", 500 * time.Millisecond, true, 0},
+		{"192.0.2.1", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		// But no more than the burst.
+		{"192.0.2.1", 10 * time.Second, true, 0},
+		{"192.0.2.1", 10 * time.Second, true, 0},
+		{"192.0.2.1", 10 * time.Second, true, 0},
+		{"192.0.2.1", 10 * time.Second, false, 500 * time.Millisecond},
+	}
+	for i, test := range tests {
+		ok, wait := l.allow(test.ip, start.Add(test.after))
+		if ok != test.ok || wait != test.wait {
+			t.Errorf("request %d: allow(%q, start+%v) = %t, %v; want %t, %v", i, test.ip, test.after, ok, wait, test.ok, test.wait)
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	// A token comes back every 100 seconds.
+	l := newRateLimiter(0.01, 2)
+	start := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	l.allow("192.0.2.1", start)
+	for i := 0; i < 2; i++ {
+		l.allow("192.0.2.2", start.Add(55*time.Second))
+	}
+	// By the next sweep, the first client's bucket has filled up again,
+	// and the second's hasn't.
+	l.allow("192.0.2.3", start.Add(100*time.Second))
+	if _, ok := l.buckets["192.0.2.1"]; ok {
+		t.Error("full bucket of 192.0.2.1 kept after the sweep")
+	}
+	if _, ok := l.buckets["192.0.2.2"]; !ok {
+		t.Error("bucket of 192.0.2.2 dropped by the sweep while it was still filling")
+	}
+	if ok, _ := l.allow("192.0.2.2", start.Add(100*time.Second)); ok {
+		t.Error("192.0.2.2 was allowed past its burst after the sweep")
+	}
+}
+
+func TestLimitRateGuardsAdmin(t *testing.T) {
+	oldLimiter := limiter
+	t.Cleanup(func() { limiter = oldLimiter })
+	limiter = newRateLimiter(0.001, 2)
+	h := limitRate(requireAdmin(adminAuth{user: "admin", password: "s3cret"}, http.NotFoundHandler()))
+	header := http.Header{"Authorization": {"Basic YWRtaW46Z3Vlc3M="}} // admin:guess
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		w := sendRequest(h.ServeHTTP, "GET", adminPrefix, header, "")
+		if w.Code != want {
+			t.Errorf("guess %d = %d; want %d", i, w.Code, want)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("guess %d has no Retry-After header", i)
+		}
+	}
+}
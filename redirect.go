@@ -0,0 +1,58 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// acmeChallengePrefix is the path under which ACME HTTP-01 challenges are
+// answered.
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// httpsRedirect returns the handler for a plaintext listener running
+// alongside a TLS one. It permanently redirects every request to the same
+// URL over HTTPS, except for ACME HTTP-01 challenges, which are passed to
+// acme if it is not nil. httpsPort is the port of the TLS listener, or
+// empty for the default.
+func httpsRedirect(httpsPort string, acme http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if acme != nil && strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+			acme.ServeHTTP(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+			host = "[" + host + "]"
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		if r.Method != "GET" && r.Method != "HEAD" {
+			// 308 keeps the method and body of other requests.
+			http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+			return
+		}
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
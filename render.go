@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// render implements the render command, which prints the response the
+// server would send for a single request, headers and all.
+func render(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	configFile := fs.String("config", "vanity.yaml", "config `file`")
+	fs.StringVar(&vanityTemplateFile, "vanity-template", "", "HTML template `file` for the page of each path (default from the config)")
+	hostFlag := fs.String("host", "", "host `name` the request is for (default from the config)")
+	goGet := fs.Bool("go-get", false, "render the response for the go command, as with ?go-get=1")
+	var headers headerFlag
+	fs.Var(&headers, "H", "`header` to send, like \"Accept: application/json\"; may be repeated")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: govanityurls render [-config file] [-vanity-template file] [-host name] [-go-get] [-H header] /path")
+	}
+	u, err := url.Parse(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if *goGet {
+		q := u.Query()
+		q.Set("go-get", "1")
+		u.RawQuery = q.Encode()
+	}
+	loadConfig(*configFile)
+	if err := setupLogging(); err != nil {
+		return err
+	}
+	host := u.Host
+	if *hostFlag != "" {
+		host = *hostFlag
+	}
+	if host == "" {
+		host = hosts[0].host
+	}
+	if host == "" {
+		return fmt.Errorf("the top-level paths have no host; set host: in the config or -host")
+	}
+	r := httptest.NewRequest("GET", u.RequestURI(), nil)
+	r.Host = host
+	for _, h := range headers {
+		r.Header.Add(h[0], h[1])
+	}
+	rec := httptest.NewRecorder()
+	// Keep the access log, if any, out of the output.
+	handle(rec, withoutObservation(r))
+	return rec.Result().Write(os.Stdout)
+}
+
+// headerFlag collects the headers given with -H.
+type headerFlag [][2]string
+
+func (f *headerFlag) String() string {
+	return ""
+}
+
+func (f *headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("header %q is not like \"Name: value\"", s)
+	}
+	*f = append(*f, [2]string{strings.TrimSpace(k), strings.TrimSpace(v)})
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// errorReporter is told about errors that need a human's attention, such
+// as a page that can't be rendered, which might otherwise go unnoticed
+// while it breaks builds.
+type errorReporter interface {
+	ReportError(r *http.Request, err error)
+}
+
+// errorReporters are told about every error reported.
+var errorReporters []errorReporter
+
+// reportError tells errorReporters about err, which happened while
+// serving r.
+func reportError(r *http.Request, err error) {
+	for _, rep := range errorReporters {
+		rep.ReportError(r, err)
+	}
+}
+
+// recoverPanic is deferred by handlers to log and report a panic, replying
+// with a 500 if nothing has been written yet. Aborted handlers are let
+// through.
+func recoverPanic(w *statusRecorder, r *http.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+		panic(v)
+	}
+	err := fmt.Errorf("panic: %v\n\n%s", v, debug.Stack())
+	slog.ErrorContext(r.Context(), "panic serving request", "host", r.Host, "path", r.URL.Path, "err", err)
+	reportError(r, err)
+	if w.status == 0 {
+		httpError(w, r, "internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// robotsDisallow lists the path prefixes that crawlers are asked to stay
+// out of. "/" excludes crawlers entirely.
+var robotsDisallow []string
+
+func (h *vanityHost) serveRobots(w http.ResponseWriter, r *http.Request, host string) {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "User-agent: *")
+	if len(robotsDisallow) == 0 {
+		fmt.Fprintln(&buf, "Disallow:")
+	}
+	for _, prefix := range robotsDisallow {
+		fmt.Fprintf(&buf, "Disallow: %s\n", prefix)
+	}
+	if !h.indexDisabled {
+		fmt.Fprintf(&buf, "\nSitemap: %s://%s/sitemap.xml\n", requestScheme(r), host)
+	}
+	writeResponse(w, r, http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+}
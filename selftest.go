@@ -0,0 +1,205 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// selftest implements the selftest command, which serves the config on a
+// loopback port and has the go command download the module at each path
+// from it, to catch broken paths before they are deployed.
+func selftest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	configFile := fs.String("config", "vanity.yaml", "config `file`")
+	hostFlag := fs.String("host", "", "host `name` the top-level paths are served on (default from the config)")
+	goCmd := fs.String("go", "go", "go `command` to run")
+	timeout := fs.Duration("timeout", 2*time.Minute, "how long to wait for each module to download")
+	fs.Parse(args)
+	named, err := loadStatic(*configFile, *hostFlag)
+	if err != nil {
+		return err
+	}
+	var (
+		vanityHosts []string
+		imports     []string
+	)
+	for _, n := range named {
+		vanityHosts = append(vanityHosts, n.name)
+		for path, e := range n.pathMap() {
+			if len(fs.Args()) > 0 && !containsString(fs.Args(), n.name+pathPrefix+path) {
+				continue
+			}
+			if e.Proxy {
+				// Proxied modules are served by the server itself, which
+				// isn't reachable by the host name here.
+				continue
+			}
+			imports = append(imports, n.name+pathPrefix+path)
+		}
+	}
+	sort.Strings(imports)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: selftestProxy(vanityHosts)}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	tmp, err := os.MkdirTemp("", "govanityurls-selftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	proxyURL := "http://" + ln.Addr().String()
+	hostList := strings.Join(vanityHosts, ",")
+	env := append(os.Environ(),
+		"HTTP_PROXY="+proxyURL, "http_proxy="+proxyURL,
+		"HTTPS_PROXY="+proxyURL, "https_proxy="+proxyURL,
+		"NO_PROXY=", "no_proxy=",
+		"GOINSECURE="+hostList,
+		"GOPRIVATE="+hostList,
+		"GOMODCACHE="+tmp+"/mod",
+		"GOFLAGS=-modcacherw",
+		"GO111MODULE=on",
+		"GOTOOLCHAIN=local",
+	)
+	failed := 0
+	for _, imp := range imports {
+		version, err := selftestDownload(*goCmd, tmp, env, imp, *timeout)
+		if err != nil {
+			fmt.Printf("FAIL\t%s\n\t%s\n", imp, strings.ReplaceAll(strings.TrimSpace(err.Error()), "\n", "\n\t"))
+			failed++
+			continue
+		}
+		fmt.Printf("ok  \t%s\t%s\n", imp, version)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d paths failed", failed, len(imports))
+	}
+	return nil
+}
+
+// selftestDownload has the go command download the latest version of the
+// module at imp, and returns the version.
+func selftestDownload(goCmd, dir string, env []string, imp string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, goCmd, "mod", "download", "-json", imp+"@latest")
+	cmd.Dir, cmd.Env = dir, env
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	runErr := cmd.Run()
+	var result struct {
+		Version string
+		Error   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		if runErr != nil {
+			return "", fmt.Errorf("%v\n%s", runErr, stderr.Bytes())
+		}
+		return "", err
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("%s", result.Error)
+	}
+	if runErr != nil {
+		return "", fmt.Errorf("%v\n%s", runErr, stderr.Bytes())
+	}
+	return result.Version, nil
+}
+
+// selftestProxy returns a forward proxy for the go command that serves
+// plain HTTP requests for vanityHosts itself and passes everything else
+// on. HTTPS connections to vanityHosts are refused, so that the go command
+// falls back to plain HTTP for them.
+func selftestProxy(vanityHosts []string) http.Handler {
+	isVanity := func(hostport string) bool {
+		host := hostport
+		if h, _, err := net.SplitHostPort(hostport); err == nil {
+			host = h
+		}
+		for _, vh := range vanityHosts {
+			if strings.EqualFold(host, vh) {
+				return true
+			}
+		}
+		return false
+	}
+	forward := &httputil.ReverseProxy{Director: func(*http.Request) {}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "CONNECT" && isVanity(r.Host):
+			http.Error(w, "use plain HTTP", http.StatusBadGateway)
+		case r.Method == "CONNECT":
+			tunnel(w, r)
+		case isVanity(r.Host):
+			handle(w, r)
+		case r.URL.IsAbs():
+			forward.ServeHTTP(w, r)
+		default:
+			http.Error(w, "not a proxy request", http.StatusBadRequest)
+		}
+	})
+}
+
+// tunnel connects the client of the CONNECT request r to the host it
+// asks for.
+func tunnel(w http.ResponseWriter, r *http.Request) {
+	conn, err := net.DialTimeout("tcp", r.Host, 30*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer conn.Close()
+	client, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Close()
+	io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go io.Copy(conn, buf)
+	// Closing both connections on the way out ends the copy the other
+	// way.
+	io.Copy(client, conn)
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// sentryReporter reports errors to Sentry.
+type sentryReporter struct {
+	client *sentry.Client
+}
+
+// newSentryReporter returns a reporter that sends errors to the Sentry
+// project with dsn.
+func newSentryReporter(dsn string) (*sentryReporter, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:     dsn,
+		Release: buildInfo().Revision,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sentryReporter{client}, nil
+}
+
+func (s *sentryReporter) ReportError(r *http.Request, err error) {
+	hub := sentry.NewHub(s.client, sentry.NewScope())
+	hub.Scope().SetRequest(r)
+	hub.Scope().SetTag("request_id", requestID(r.Context()))
+	hub.CaptureException(err)
+}
+
+// flush waits up to timeout for reports to be sent.
+func (s *sentryReporter) flush(timeout time.Duration) {
+	s.client.Flush(timeout)
+}
@@ -0,0 +1,515 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine && !lambda && !cloudfunctions && !caddy
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// listenFlag collects the addresses given with -listen, which may be
+// repeated or given as a comma-separated list.
+type listenFlag []string
+
+func (f *listenFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listenFlag) Set(s string) error {
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			*f = append(*f, addr)
+		}
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		var cmd func([]string) error
+		switch os.Args[1] {
+		case "generate":
+			cmd = generate
+		case "export":
+			cmd = export
+		case "selftest":
+			cmd = selftest
+		case "import":
+			cmd = importHost
+		case "render":
+			cmd = render
+		}
+		if cmd != nil {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	var listenFlags listenFlag
+	flag.Var(&listenFlags, "listen", "`address` to listen on; may be repeated (default from the config, or :$PORT, or :8080)")
+	configFile := flag.String("config", "vanity.yaml", "config `file`")
+	flag.StringVar(&vanityTemplateFile, "vanity-template", "", "HTML template `file` for the page of each path (default from the config)")
+	socketModeFlag := flag.String("socket-mode", "", "permissions of unix sockets, in `octal` (default from the config)")
+	tlsCertFlag := flag.String("tls-cert", "", "TLS certificate `file`; serves HTTPS if set along with -tls-key (default from the config)")
+	tlsKeyFlag := flag.String("tls-key", "", "TLS private key `file` (default from the config)")
+	acmeCacheFlag := flag.String("acme-cache", "", "`directory` to keep certificates from Let's Encrypt in; obtains them automatically if set (default from the config)")
+	h2cFlag := flag.Bool("h2c", false, "accept HTTP/2 without TLS, for load balancers that speak h2c (default from the config)")
+	drainTimeoutFlag := flag.Duration("drain-timeout", 0, "how long to wait for requests in progress to finish when shutting down (default from the config, or 30s)")
+	var timeoutFlags timeoutsConfig
+	flag.DurationVar(&timeoutFlags.ReadHeader, "read-header-timeout", 0, "how long to wait for a request's headers (default from the config, or 10s)")
+	flag.DurationVar(&timeoutFlags.Read, "read-timeout", 0, "how long to wait for a whole request (default from the config, or 30s)")
+	flag.DurationVar(&timeoutFlags.Write, "write-timeout", 0, "how long a response may take (default from the config, or 5m)")
+	flag.DurationVar(&timeoutFlags.Idle, "idle-timeout", 0, "how long to keep idle connections open (default from the config, or 2m)")
+	maxHeaderBytesFlag := flag.Int("max-header-bytes", 0, "largest request headers to accept, in bytes (default from the config, or 32768)")
+	pprofFlag := flag.Bool("pprof", false, "serve profiles under /-/debug/pprof/ to requests with the admin credentials (default from the config)")
+	expvarFlag := flag.Bool("expvar", false, "serve counters under /-/debug/vars to requests with the admin credentials (default from the config)")
+	metricsFlag := flag.Bool("metrics", false, "serve Prometheus metrics at /metrics (default from the config)")
+	otlpEndpointFlag := flag.String("otlp-endpoint", "", "`URL` of an OpenTelemetry collector to send traces to over OTLP/HTTP (default from the config)")
+	statsdFlag := flag.String("statsd", "", "UDP `address` of a StatsD server to send metrics to (default from the config)")
+	logLevelFlag := flag.String("log-level", "", "least severe `level` to log: debug, info, warn, or error (default from the config, or info)")
+	logFormatFlag := flag.String("log-format", "", "`format` of the log: text or json (default from the config, or text)")
+	maintenanceFlag := flag.Bool("maintenance", false, "start in maintenance mode, answering every request with a 503 (default from the config)")
+	adminListenFlag := flag.String("admin-listen", "", "`address` to serve the management endpoints on, instead of the public listeners (default from the config)")
+	http3Flag := flag.String("http3", "", "UDP `address` to serve HTTP/3 on; requires TLS (default from the config)")
+	httpRedirectFlag := flag.String("http-redirect", "", "`address` of a plain HTTP listener that redirects to HTTPS (default from the config)")
+	flag.Parse()
+	loadConfig(*configFile)
+	if *logLevelFlag != "" {
+		logLevel = *logLevelFlag
+	}
+	if *logFormatFlag != "" {
+		logFormat = *logFormatFlag
+	}
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+	logConfig()
+	if *socketModeFlag != "" {
+		socketMode = *socketModeFlag
+	}
+	if *tlsCertFlag != "" {
+		tlsCert = *tlsCertFlag
+	}
+	if *tlsKeyFlag != "" {
+		tlsKey = *tlsKeyFlag
+	}
+	if *acmeCacheFlag != "" {
+		acmeCache = *acmeCacheFlag
+	}
+	if *httpRedirectFlag != "" {
+		httpRedirectAddr = *httpRedirectFlag
+	}
+	if *http3Flag != "" {
+		http3Addr = *http3Flag
+	}
+	if *drainTimeoutFlag != 0 {
+		drainTimeout = *drainTimeoutFlag
+	}
+	timeouts.override(timeoutFlags)
+	if *maxHeaderBytesFlag > 0 {
+		maxHeaderBytes = *maxHeaderBytesFlag
+	}
+	if *h2cFlag {
+		h2c = true
+	}
+	if *pprofFlag {
+		pprofEnabled = true
+	}
+	if *expvarFlag {
+		expvarEnabled = true
+	}
+	if *adminListenFlag != "" {
+		adminAddr = *adminListenFlag
+	}
+	auth := adminAuthFromEnv()
+	if adminOIDC.Issuer != "" {
+		login, err := newOIDCLogin(context.Background(), adminOIDC)
+		if err != nil {
+			log.Fatalf("admin_oidc: %v", err)
+		}
+		auth.login = login
+	}
+	// guard requires the admin credentials, if any. Without them, the
+	// management endpoints are only served on the admin listener.
+	guard := func(h http.Handler) http.Handler {
+		if auth.enabled() {
+			return requireAdmin(auth, h)
+		}
+		return h
+	}
+	if (pprofEnabled || expvarEnabled) && !auth.enabled() && adminAddr == "" {
+		log.Fatalf("pprof and expvar require an admin listener, or $%s, or $%s and $%s", adminTokenEnv, adminUserEnv, adminPasswordEnv)
+	}
+	if auth.enabled() || adminAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc(debugPrefix+"render", serveDebugRender)
+		if pprofEnabled {
+			mux.Handle(debugPrefix+"pprof/", newPprofHandler())
+		}
+		if expvarEnabled {
+			mux.Handle(debugPrefix+"vars", expvar.Handler())
+		}
+		debugHandler = limitRate(guard(mux))
+	}
+	if *maintenanceFlag {
+		maintenance.Store(true)
+	}
+	if auth.enabled() || adminAddr != "" {
+		adminHandler = limitRate(guard(newAdminMux()))
+		editHandler = guard(http.HandlerFunc(servePathEdit))
+	}
+	if *metricsFlag {
+		metricsEnabled = true
+	}
+	if *otlpEndpointFlag != "" {
+		otlpEndpoint = *otlpEndpointFlag
+	}
+	if sentryDSN == "" {
+		sentryDSN = os.Getenv("SENTRY_DSN")
+	}
+	if sentryDSN != "" {
+		rep, err := newSentryReporter(sentryDSN)
+		if err != nil {
+			log.Fatalf("sentry: %v", err)
+		}
+		errorReporters = append(errorReporters, rep)
+		// Send what was reported while draining before exiting.
+		defer rep.flush(5 * time.Second)
+	}
+	if *statsdFlag != "" {
+		statsdAddr = *statsdFlag
+	}
+	if statsdAddr != "" {
+		observe, err := newStatsdObserver(statsdAddr, statsdTags)
+		if err != nil {
+			log.Fatal(err)
+		}
+		requestObservers = append(requestObservers, observe)
+	}
+	if metricsEnabled {
+		metricsHandler = guard(newMetricsHandler())
+	}
+	var adminSrv *http.Server
+	if adminAddr != "" {
+		var err error
+		adminSrv, err = newAdminServer()
+		if err != nil {
+			log.Fatalf("admin: %v", err)
+		}
+		// Keep them off the public listeners.
+		debugHandler, adminHandler, metricsHandler, editHandler = nil, nil, nil, nil
+	}
+	srv := newServer(nil)
+	if h2c {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	var acmeChallenges http.Handler
+	if (tlsCert == "") != (tlsKey == "") {
+		log.Fatal("both a TLS certificate and key are required")
+	}
+	switch {
+	case tlsCert != "" && acmeCache != "":
+		log.Fatal("a TLS certificate and ACME can't be used together")
+	case tlsCert != "":
+		certs, err := newCertReloader(tlsCert, tlsKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	case acmeCache != "":
+		m, err := newACMEManager(acmeCache, acmeEmail)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.TLSConfig = m.TLSConfig()
+		acmeChallenges = m.HTTPHandler(nil)
+	case httpRedirectAddr != "":
+		log.Fatal("redirecting to HTTPS requires a TLS certificate or ACME")
+	case http3Addr != "":
+		log.Fatal("HTTP/3 requires a TLS certificate or ACME")
+	}
+
+	inherited, ready, err := inheritedListeners()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// An upgraded process is reported to systemd by the one it replaces.
+	upgraded := inherited != nil
+	if upgraded {
+		reloadedAt = loadTime
+	}
+	listeners, err := systemdListeners()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// named holds every listener by the address it was opened for, so that
+	// they can be handed down to an upgraded process.
+	named := make(map[string]net.Listener)
+	for i, ln := range listeners {
+		named["fd:"+strconv.Itoa(i)] = ln
+	}
+	openListener := func(addr string) net.Listener {
+		ln, ok := inherited[addr]
+		if ok {
+			delete(inherited, addr)
+		} else {
+			var err error
+			if ln, err = listen(addr); err != nil {
+				log.Fatal(err)
+			}
+		}
+		named[addr] = ln
+		return ln
+	}
+	addrs := []string(listenFlags)
+	if len(addrs) == 0 {
+		addrs = listenAddrs
+	}
+	if len(addrs) == 0 && len(listeners) == 0 && len(inherited) == 0 {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		addrs = []string{":" + port}
+	}
+	for _, addr := range addrs {
+		listeners = append(listeners, openListener(addr))
+	}
+	var redirectLn net.Listener
+	if httpRedirectAddr != "" {
+		redirectLn = openListener(httpRedirectAddr)
+	}
+	var adminLn net.Listener
+	if adminSrv != nil {
+		adminLn = openListener(adminAddr)
+	}
+	// Anything else handed down came from systemd.
+	for name, ln := range inherited {
+		named[name] = ln
+		listeners = append(listeners, ln)
+	}
+
+	// Not the default mux: importing net/http/pprof registers its
+	// unauthenticated handlers there.
+	var handler http.Handler = http.HandlerFunc(handle)
+	errc := make(chan error, len(listeners)+3)
+	shutdowns := []func(context.Context) error{srv.Shutdown}
+	if otlpEndpoint != "" {
+		var flush func(context.Context) error
+		handler, flush, err = startTracing(context.Background(), otlpEndpoint, handler)
+		if err != nil {
+			log.Fatalf("tracing: %v", err)
+		}
+		// Run after the servers have drained, to send their last spans.
+		defer flush(context.Background())
+		slog.Info("sending traces", "endpoint", otlpEndpoint)
+	}
+	srv.Handler = handler
+	if http3Addr != "" {
+		h3 := newHTTP3Server(http3Addr, srv.TLSConfig, handler)
+		srv.Handler = advertiseHTTP3(h3, handler)
+		shutdowns = append(shutdowns, h3.Shutdown)
+		slog.Info("serving HTTP/3", "addr", http3Addr)
+		go func() {
+			errc <- h3.ListenAndServe()
+		}()
+	}
+	// Decide up front: Serve fills in srv.TLSConfig to set up HTTP/2.
+	useTLS := srv.TLSConfig != nil
+	for _, ln := range listeners {
+		slog.Info("listening", "addr", ln.Addr().String())
+		go func(ln net.Listener) {
+			if useTLS {
+				errc <- srv.ServeTLS(ln, "", "")
+			} else {
+				errc <- srv.Serve(ln)
+			}
+		}(ln)
+	}
+	if redirectLn != nil {
+		var httpsPort string
+		if addr, ok := listeners[0].Addr().(*net.TCPAddr); ok {
+			httpsPort = strconv.Itoa(addr.Port)
+		}
+		redirectSrv := newServer(httpsRedirect(httpsPort, acmeChallenges))
+		shutdowns = append(shutdowns, redirectSrv.Shutdown)
+		slog.Info("redirecting to HTTPS", "addr", redirectLn.Addr().String())
+		go func() {
+			errc <- redirectSrv.Serve(redirectLn)
+		}()
+	}
+	if adminLn != nil {
+		shutdowns = append(shutdowns, adminSrv.Shutdown)
+		slog.Info("serving management endpoints", "addr", adminLn.Addr().String(), "tls", adminSrv.TLSConfig != nil, "client_ca", adminClientCA)
+		go func() {
+			if adminSrv.TLSConfig != nil {
+				errc <- adminSrv.ServeTLS(adminLn, "", "")
+			} else {
+				errc <- adminSrv.Serve(adminLn)
+			}
+		}()
+	}
+	ready()
+	isReady.Store(true)
+	if !upgraded {
+		if err := sdNotify("READY=1"); err != nil {
+			slog.Warn("cannot tell systemd the server is ready", "err", err)
+		}
+	}
+
+	// Reloads asked for from the admin page are done the same way as
+	// after SIGUSR2.
+	reloads := make(chan chan error)
+	stopping := make(chan struct{})
+	reloadConfig = func() error {
+		done := make(chan error, 1)
+		select {
+		case reloads <- done:
+			return <-done
+		case <-stopping:
+			return errors.New("the server is shutting down")
+		}
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, upgradeSignals...)...)
+wait:
+	for {
+		select {
+		case err := <-errc:
+			log.Fatal(err)
+		case done := <-reloads:
+			slog.Info("upgrading", "reason", "reload requested")
+			err := upgrade(named)
+			done <- err
+			if err != nil {
+				slog.Error("upgrade failed", "err", err)
+				setReloadError(err)
+				continue
+			}
+			slog.Info("shutting down", "reason", "reload requested", "drain_timeout", drainTimeout.String())
+			isReady.Store(false)
+			break wait
+		case sig := <-stop:
+			if sig != os.Interrupt && sig != syscall.SIGTERM {
+				slog.Info("upgrading", "signal", sig.String())
+				err := upgrade(named)
+				e := auditEvent{Action: "reload", Signal: sig.String()}
+				if data, err := os.ReadFile(*configFile); err == nil {
+					e.Diff = diffLines(string(configData), string(data))
+				}
+				if err != nil {
+					e.Error = err.Error()
+				}
+				recordAudit(e)
+				if err != nil {
+					slog.Error("upgrade failed", "err", err)
+					setReloadError(err)
+					continue
+				}
+			}
+			slog.Info("shutting down", "signal", sig.String(), "drain_timeout", drainTimeout.String())
+			isReady.Store(false)
+			break wait
+		}
+	}
+	close(stopping)
+	for _, h := range hosts {
+		h.stopDiscovery()
+	}
+	// Stop accepting connections, then give requests in progress, like a
+	// slow go get through the module proxy, a chance to finish.
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, shutdown := range shutdowns {
+		wg.Add(1)
+		go func(shutdown func(context.Context) error) {
+			defer wg.Done()
+			if err := shutdown(ctx); err != nil {
+				slog.Error("shutdown", "err", err)
+			}
+		}(shutdown)
+	}
+	wg.Wait()
+	slog.Info("stopped")
+}
+
+// newServer returns a server for h with the configured timeouts and
+// limits.
+func newServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+}
+
+// listen listens on addr, which is either a TCP address or the path of a
+// unix socket prefixed with "unix:".
+func listen(addr string) (net.Listener, error) {
+	path := strings.TrimPrefix(addr, "unix:")
+	if path == addr {
+		return net.Listen("tcp", addr)
+	}
+	// Remove the socket left behind by a previous run, but nothing else.
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if socketMode != "" {
+		mode, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("socket mode %q: %v", socketMode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// defaultHost returns the host that r was sent to.
+func defaultHost(r *http.Request) string {
+	return requestHost(r)
+}
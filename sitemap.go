@@ -0,0 +1,48 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// serveSitemap serves a sitemap (https://www.sitemaps.org/) listing the
+// index and every configured path that search engines may index.
+func (h *vanityHost) serveSitemap(w http.ResponseWriter, r *http.Request, host string) {
+	scheme := requestScheme(r)
+	type url struct {
+		Loc string `xml:"loc"`
+	}
+	sitemap := struct {
+		XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		URLs    []url    `xml:"url"`
+	}{
+		URLs: []url{{Loc: scheme + "://" + host + "/"}},
+	}
+	for _, e := range h.indexEntries(host) {
+		if h.pathMap()[e.Path].NoIndex {
+			continue
+		}
+		sitemap.URLs = append(sitemap.URLs, url{Loc: scheme + "://" + e.Import})
+	}
+	data, err := xml.MarshalIndent(sitemap, "", "  ")
+	if err != nil {
+		logRenderError(r, "sitemap", err)
+		httpError(w, r, "cannot render the sitemap", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), data...))
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// staticPrefix is the path under which the assets directory is served.
+const staticPrefix = "/-/static/"
+
+// assets serves the configured assets directory, or is nil if there is
+// none.
+var assets http.Handler
+
+func (h *vanityHost) serveStatic(w http.ResponseWriter, r *http.Request, host string) {
+	// Don't list the contents of directories.
+	if assets == nil || strings.HasSuffix(r.URL.Path, "/") {
+		h.serveNotFound(w, r, host)
+		return
+	}
+	http.StripPrefix(strings.TrimSuffix(staticPrefix, "/"), assets).ServeHTTP(w, r)
+}
+
+// defaultFavicon is served at /favicon.ico unless another is configured.
+//
+//go:embed favicon.ico
+var defaultFavicon []byte
+
+// favicon is the content of /favicon.ico.
+var favicon = defaultFavicon
+
+func serveFavicon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "favicon.ico", time.Time{}, bytes.NewReader(favicon))
+}
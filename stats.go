@@ -0,0 +1,154 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stats counts what the server has done, for monitoring.
+var stats struct {
+	requests    atomic.Int64
+	notFound    atomic.Int64
+	configLoads atomic.Int64
+	// configLoadFailures counts the reloads that failed, leaving the
+	// server running with the config it had.
+	configLoadFailures atomic.Int64
+
+	mu       sync.Mutex
+	pathHits map[string]int64 // by requestRecord.Key
+}
+
+// unmatchedKey is the key of requests that matched no configured path.
+const unmatchedKey = "(unmatched)"
+
+// pathHits returns a copy of the number of requests for each configured
+// path, along with those for no path under unmatchedKey.
+func pathHits() map[string]int64 {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	m := make(map[string]int64, len(stats.pathHits))
+	for pkg, n := range stats.pathHits {
+		m[pkg] = n
+	}
+	return m
+}
+
+// requestRecord describes a request once it has been served.
+type requestRecord struct {
+	Request *http.Request
+	Start   time.Time
+	// Path is the import path of the configured path the request was
+	// for, if any, and Subpath is the rest of the request's path.
+	Path    string
+	Subpath string
+	// Key names the configured path the request was for by the configured
+	// host name and the path, or is unmatchedKey. Unlike Path, it never
+	// comes from the request, so there is a bounded number of them to
+	// count requests by.
+	Key    string
+	Status int
+	Bytes  int64
+	// GoGet is set if the request had go-get=1 in its query, and
+	// GoCommand if it came from the go command rather than a browser.
+	GoGet     bool
+	GoCommand bool
+	Duration  time.Duration
+}
+
+// metricsPath is where metrics are served for scraping by Prometheus.
+const metricsPath = "/metrics"
+
+// metricsHandler serves Prometheus metrics, if they are turned on.
+var metricsHandler http.Handler
+
+// requestObservers are told about every request served, to feed the
+// metrics backends.
+var requestObservers []func(requestRecord)
+
+// observeRequest counts a request that has been served.
+func observeRequest(rr requestRecord) {
+	stats.requests.Add(1)
+	if rr.Status == http.StatusNotFound {
+		stats.notFound.Add(1)
+	}
+	stats.mu.Lock()
+	if stats.pathHits == nil {
+		stats.pathHits = make(map[string]int64)
+	}
+	stats.pathHits[rr.Key]++
+	stats.mu.Unlock()
+	for _, observe := range requestObservers {
+		observe(rr)
+	}
+	if accessLog != nil {
+		accessLog(rr)
+	}
+}
+
+type unobservedKey struct{}
+
+// withoutObservation returns r marked as made up by the server itself, to
+// show how a request would be handled. Such requests aren't counted,
+// logged, or charged against the rate limit of the client.
+func withoutObservation(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), unobservedKey{}, true))
+}
+
+// isObserved reports whether r wasn't marked by withoutObservation.
+func isObserved(r *http.Request) bool {
+	unobserved, _ := r.Context().Value(unobservedKey{}).(bool)
+	return !unobserved
+}
+
+// statusRecorder remembers the status of the response written through it,
+// along with what the handler learned about the request.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	// path is the import path of the configured path the request was for,
+	// and subpath is the rest of the request's path. key names the
+	// configured path, as in requestRecord.
+	path, subpath string
+	key           string
+	// goCommand is set if the request came from the go command.
+	goCommand bool
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// newStatsdObserver returns a request observer that sends metrics to the
+// StatsD server at addr over UDP. Each metric carries tags in the
+// DogStatsD format, starting with tags.
+func newStatsdObserver(addr string, tags []string) (func(requestRecord), error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+	for _, t := range tags {
+		if strings.ContainsAny(t, ",|#\n") {
+			return nil, fmt.Errorf("statsd_tags: invalid tag %q", t)
+		}
+	}
+	return func(rr requestRecord) {
+		client := "browser"
+		if rr.GoCommand {
+			client = "go"
+		}
+		t := append(tags[:len(tags):len(tags)], "status:"+strconv.Itoa(rr.Status), "client:"+client, "path:"+rr.Key)
+		suffix := "|#" + strings.Join(t, ",")
+		// Both metrics go in one packet. Delivery isn't checked: a lost
+		// metric is better than a slow request.
+		fmt.Fprintf(conn, "govanityurls.requests:1|c%s\ngovanityurls.request_duration:%d|ms%s",
+			suffix, rr.Duration.Milliseconds(), suffix)
+	}, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// startTime is when the server started.
+var startTime = time.Now()
+
+// lastReload records the last failure to reload the config, which leaves
+// the server running with the config it had.
+var lastReload struct {
+	mu   sync.Mutex
+	err  string
+	time time.Time
+}
+
+// reloadedAt is when the config was last reloaded successfully. A reload
+// starts a new process to take over from the running one, so it is when
+// this process loaded the config if it took over from another, and zero
+// otherwise.
+var reloadedAt time.Time
+
+// setReloadError records that reloading the config failed with err.
+func setReloadError(err error) {
+	stats.configLoadFailures.Add(1)
+	lastReload.mu.Lock()
+	defer lastReload.mu.Unlock()
+	lastReload.err = err.Error()
+	lastReload.time = time.Now()
+}
+
+type statusPathHits struct {
+	Path string `json:"path"`
+	Hits int64  `json:"hits"`
+}
+
+// serverStatus is shown on the status page.
+type serverStatus struct {
+	Version         versionInfo      `json:"version"`
+	Config          string           `json:"config"`
+	ConfigLoaded    time.Time        `json:"config_loaded"`
+	ConfigHash      string           `json:"config_hash"`
+	Started         time.Time        `json:"started"`
+	Hosts           int              `json:"hosts"`
+	Paths           int              `json:"paths"`
+	Maintenance     bool             `json:"maintenance"`
+	Requests        int64            `json:"requests"`
+	NotFound        int64            `json:"not_found"`
+	LastReloadError string           `json:"last_reload_error,omitempty"`
+	LastReloadTime  *time.Time       `json:"last_reload_error_time,omitempty"`
+	PathHits        []statusPathHits `json:"path_hits"`
+}
+
+// serveStatus serves /-/admin/status, an overview of the server for
+// operators, as HTML or, if asked for, JSON.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	st := serverStatus{
+		Version:      buildInfo(),
+		Config:       configFile,
+		ConfigLoaded: loadTime.UTC(),
+		ConfigHash:   configHash,
+		Started:      startTime.UTC(),
+		Hosts:        len(hosts),
+		Maintenance:  inMaintenance(),
+		Requests:     stats.requests.Load(),
+		NotFound:     stats.notFound.Load(),
+	}
+	for _, h := range hosts {
+		st.Paths += len(h.pathMap())
+	}
+	lastReload.mu.Lock()
+	if lastReload.err != "" {
+		st.LastReloadError = lastReload.err
+		t := lastReload.time.UTC()
+		st.LastReloadTime = &t
+	}
+	lastReload.mu.Unlock()
+	for path, n := range pathHits() {
+		st.PathHits = append(st.PathHits, statusPathHits{path, n})
+	}
+	sort.Slice(st.PathHits, func(i, j int) bool {
+		if st.PathHits[i].Hits != st.PathHits[j].Hits {
+			return st.PathHits[i].Hits > st.PathHits[j].Hits
+		}
+		return st.PathHits[i].Path < st.PathHits[j].Path
+	})
+	if r.URL.Query().Get("format") == "json" || wantsJSON(r) {
+		writeJSON(w, r, http.StatusOK, st)
+		return
+	}
+	var buf bytes.Buffer
+	if err := statusTmpl.Execute(&buf, st); err != nil {
+		logRenderError(r, "status", err)
+		httpError(w, r, "cannot render the page", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+var statusTmpl = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Status</title>
+</head>
+<body>
+<h1>Status</h1>
+<table>
+<tr><th>Version</th><td>{{.Version.Version}}{{with .Version.Revision}} ({{.}}{{if $.Version.Modified}}, modified{{end}}){{end}}</td></tr>
+<tr><th>Started</th><td>{{.Started.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Config</th><td>{{.Config}}, loaded {{.ConfigLoaded.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Config SHA-256</th><td><code>{{.ConfigHash}}</code></td></tr>
+<tr><th>Hosts</th><td>{{.Hosts}}</td></tr>
+<tr><th>Paths</th><td>{{.Paths}}</td></tr>
+<tr><th>Maintenance</th><td>{{if .Maintenance}}on{{else}}off{{end}}</td></tr>
+<tr><th>Requests</th><td>{{.Requests}} ({{.NotFound}} not found)</td></tr>
+{{with .LastReloadError}}<tr><th>Last reload error</th><td>{{.}} at {{$.LastReloadTime.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+{{end}}</table>
+<h2>Hits since start</h2>
+{{if .PathHits}}<table>
+{{range .PathHits}}<tr><td>{{.Path}}</td><td>{{.Hits}}</td></tr>
+{{end}}</table>
+{{else}}<p>None yet.</p>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,160 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine && unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// upgradeEnv lists the addresses of the listeners handed down to an
+// upgraded process, in the order of their file descriptors. The file
+// descriptor after them is a pipe on which the new process reports that
+// it is serving.
+const upgradeEnv = "GOVANITYURLS_UPGRADE_LISTENERS"
+
+// upgradeTimeout is how long to wait for an upgraded process to start
+// serving before giving up on it.
+const upgradeTimeout = time.Minute
+
+// upgradeSignals are the signals that start an upgrade.
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// inheritedListeners returns the listeners handed down by the process
+// being upgraded, by address, along with a function that tells that
+// process the new one is serving.
+func inheritedListeners() (map[string]net.Listener, func(), error) {
+	env, ok := os.LookupEnv(upgradeEnv)
+	if !ok {
+		return nil, func() {}, nil
+	}
+	os.Unsetenv(upgradeEnv)
+	var names []string
+	if env != "" {
+		names = strings.Split(env, ",")
+	}
+	listeners := make(map[string]net.Listener, len(names))
+	for i, name := range names {
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("inherit %s: %v", name, err)
+		}
+		// Clean up sockets we created, as if they had been opened here,
+		// but not those from systemd.
+		if ul, ok := ln.(*net.UnixListener); ok && strings.HasPrefix(name, "unix:") {
+			ul.SetUnlinkOnClose(true)
+		}
+		listeners[name] = ln
+	}
+	ready := os.NewFile(uintptr(listenFDsStart+len(names)), "ready")
+	return listeners, func() {
+		ready.Write([]byte{1})
+		ready.Close()
+	}, nil
+}
+
+// upgrade starts a new copy of the executable, which may have been
+// replaced since this process started, handing it listeners. It returns
+// once the new process is serving, after which this one should shut down.
+// Under systemd, the new process is reported as the service's main
+// process, so that this one exiting doesn't stop the service.
+func upgrade(listeners map[string]net.Listener) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var (
+		names []string
+		files []*os.File
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for name, ln := range listeners {
+		filer, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("can't hand down %s", name)
+		}
+		f, err := filer.File()
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), upgradeEnv+"="+strings.Join(names, ","))
+	cmd.ExtraFiles = append(files, w)
+	err = cmd.Start()
+	w.Close()
+	// Handing the files to the new process put them in blocking mode, and
+	// with them our listeners, which share their open file descriptions.
+	// A blocked accept would keep a listener from ever closing.
+	for _, f := range files {
+		if rc, err := f.SyscallConn(); err == nil {
+			rc.Control(func(fd uintptr) {
+				syscall.SetNonblock(int(fd), true)
+			})
+		}
+	}
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			return errors.New("new process exited before serving")
+		}
+	case <-time.After(upgradeTimeout):
+		cmd.Process.Kill()
+		return errors.New("timed out waiting for new process")
+	}
+	if err := sdNotify(fmt.Sprintf("MAINPID=%d", cmd.Process.Pid)); err != nil {
+		slog.Warn("cannot hand the service over to the new process", "err", err)
+	}
+	// The new process now serves the unix sockets, so leave them be.
+	for _, ln := range listeners {
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/GoogleCloudPlatform/govanityurls/internal/vanitypage"
+)
+
+// ServeHTTP serves the page for the path r is for, or the index page for
+// "/" if no path is configured there.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	res := &matchResult{host: h.host}
+	if res.host == "" {
+		res.host = requestHost(r)
+	}
+	if r.Method == "GET" || r.Method == "HEAD" {
+		res.match, res.err = h.match(res.host, r.URL.Path)
+	}
+	h.serve.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), matchKey{}, res)))
+}
+
+// requestHost returns the host r was sent to, without its port. It is
+// whatever the client put in the Host header, unchecked.
+func requestHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	return r.Host
+}
+
+// match finds the path that current falls under, in the config or else
+// with the resolver.
+func (h *Handler) match(host, current string) (*Match, error) {
+	p, subpath := h.find(current)
+	resolved := false
+	if p == nil && h.resolver != nil && current != "/" {
+		var err error
+		p, subpath, err = h.resolve(host, current)
+		if err != nil {
+			return nil, err
+		}
+		resolved = true
+	}
+	if p == nil {
+		return nil, nil
+	}
+	return &Match{
+		Import:   host + p.path,
+		Subpath:  subpath,
+		VCS:      p.vcs,
+		Repo:     p.repo,
+		Resolved: resolved,
+		path:     p,
+	}, nil
+}
+
+// serveMatched serves the request once ServeHTTP has matched it.
+func (h *Handler) serveMatched(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res := r.Context().Value(matchKey{}).(*matchResult)
+	if res.err != nil {
+		h.logger.ErrorContext(r.Context(), "cannot look up path", "path", r.URL.Path, "err", res.err)
+		http.Error(w, "cannot look up the path", http.StatusInternalServerError)
+		return
+	}
+	if res.match == nil {
+		if r.URL.Path == "/" {
+			h.serveIndex(w, r, res.host)
+			return
+		}
+		if h.notFound != nil {
+			h.notFound.ServeHTTP(w, r)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	p, subpath := res.match.path, res.match.Subpath
+
+	// The go command only needs the meta tags. Everyone else is sent on to
+	// somewhere more interesting.
+	importPath := res.match.Import
+	pkg := importPath
+	if subpath != "" {
+		pkg += "/" + subpath
+	}
+	canonical := h.docsURLFor(pkg)
+	page := vanitypage.Page{
+		Import:    importPath,
+		Subpath:   subpath,
+		VCS:       p.vcs,
+		Repo:      p.repo,
+		Display:   p.display,
+		Canonical: canonical,
+		Data:      p.data,
+	}
+	if query := r.URL.Query(); query.Get("go-get") != "1" {
+		if h.installInstructions {
+			page.Install = &vanitypage.Install{
+				Package:     pkg,
+				Description: p.description,
+				DocsURL:     canonical,
+				SourceURL:   p.web,
+			}
+		} else {
+			page.Redirect = vanitypage.RedirectURL(p.redirect, p.web, importPath, subpath, query, h.docsURLFor)
+		}
+	}
+	if page.Redirect != "" && p.redirectMode == "http" {
+		http.Redirect(w, r, page.Redirect, http.StatusFound)
+		return
+	}
+	var buf bytes.Buffer
+	if err := h.vanityTmpl.Execute(&buf, page); err != nil {
+		h.logger.ErrorContext(r.Context(), "cannot render page", "import", pkg, "err", err)
+		http.Error(w, "cannot render the page", http.StatusInternalServerError)
+		return
+	}
+	h.writePage(w, r, buf.Bytes())
+}
+
+// indexEntry describes a single path on the index page.
+type indexEntry struct {
+	Import      string
+	Description string
+	DocsURL     string
+	Data        map[string]string
+}
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request, host string) {
+	entries := make([]indexEntry, 0, len(h.paths))
+	for _, p := range h.paths {
+		entries = append(entries, indexEntry{
+			Import:      host + p.path,
+			Description: p.description,
+			DocsURL:     h.docsURLFor(host + p.path),
+			Data:        p.data,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Import < entries[j].Import })
+	var buf bytes.Buffer
+	if err := h.indexTmpl.Execute(&buf, struct {
+		Host  string
+		Paths []indexEntry
+	}{host, entries}); err != nil {
+		h.logger.ErrorContext(r.Context(), "cannot render index page", "err", err)
+		http.Error(w, "cannot render the page", http.StatusInternalServerError)
+		return
+	}
+	h.writePage(w, r, buf.Bytes())
+}
+
+// docsURLFor returns the URL of the documentation for importPath.
+func (h *Handler) docsURLFor(importPath string) string {
+	if h.docsURLFunc != nil {
+		return h.docsURLFunc(importPath)
+	}
+	u, err := vanitypage.DocsURL(h.docsURL, importPath)
+	if err != nil {
+		h.logger.Error("cannot build docs URL", "import", importPath, "err", err)
+	}
+	return u
+}
+
+// writePage replies with the HTML page body.
+func (h *Handler) writePage(w http.ResponseWriter, r *http.Request, body []byte) {
+	if h.cacheControl != "" {
+		w.Header().Set("Cache-Control", h.cacheControl)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method != "HEAD" {
+		w.Write(body)
+	}
+}
+
+var indexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<title>{{.Host}}</title>
+</head>
+<body>
+<h1>{{.Host}}</h1>
+<ul>
+{{range .Paths}}<li><a href="{{.DocsURL}}">{{.Import}}</a>{{with .Description}} &mdash; {{.}}{{end}}</li>
+{{end}}</ul>
+</body>
+</html>`))
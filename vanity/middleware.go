@@ -0,0 +1,78 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"context"
+	"net/http"
+)
+
+// A Match describes the configured path a request was matched to.
+type Match struct {
+	// Import is the import path of the configured path, like
+	// "example.com/portmidi". Its host is the request's, and so
+	// untrusted, if the Config gives no Host.
+	Import string
+	// Subpath is the rest of the requested path, like "sub/pkg".
+	Subpath string
+	// VCS and Repo are the version control system and URL of the repo,
+	// as given in the go-import meta tag.
+	VCS  string
+	Repo string
+	// Resolved is set if the path was found with the Resolver rather
+	// than in the config.
+	Resolved bool
+
+	path *path
+}
+
+// matchKey is the context key for a request's matchResult.
+type matchKey struct{}
+
+// matchResult is what ServeHTTP found for a request.
+type matchResult struct {
+	host  string
+	match *Match
+	err   error
+}
+
+// MatchFromContext returns the path a request was matched to, given the
+// request's context. It is for middleware added with WithMiddleware, and
+// returns nil for the index page and for requests that match no path.
+func MatchFromContext(ctx context.Context) *Match {
+	res, _ := ctx.Value(matchKey{}).(*matchResult)
+	if res == nil {
+		return nil
+	}
+	return res.match
+}
+
+// WithMiddleware wraps the serving of each request in mw, the first
+// outermost, such as to check authorization or log requests. The path is
+// matched before mw is called, so MatchFromContext tells it which path
+// is being served.
+func WithMiddleware(mw ...func(http.Handler) http.Handler) Option {
+	return func(h *Handler) {
+		h.middleware = append(h.middleware, mw...)
+	}
+}
+
+// WithNotFound serves requests that match no path with notFound, in place
+// of a plain 404 page.
+func WithNotFound(notFound http.Handler) Option {
+	return func(h *Handler) {
+		h.notFound = notFound
+	}
+}
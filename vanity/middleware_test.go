@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+	"github.com/GoogleCloudPlatform/govanityurls/vanity/vanitytest"
+)
+
+func TestMiddleware(t *testing.T) {
+	const config = `
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+`
+	tests := []struct {
+		importPath string
+		// match is the import path MatchFromContext reports, or empty
+		// if it reports none.
+		match   string
+		subpath string
+	}{
+		{importPath: "example.com/portmidi", match: "example.com/portmidi"},
+		{importPath: "example.com/portmidi/sub/pkg", match: "example.com/portmidi", subpath: "sub/pkg"},
+		{importPath: "example.com/other"},
+		{importPath: "example.com/"},
+	}
+	for _, test := range tests {
+		var calls []string
+		mw := func(name string) func(http.Handler) http.Handler {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					calls = append(calls, name)
+					m := vanity.MatchFromContext(r.Context())
+					var match, subpath string
+					if m != nil {
+						match, subpath = m.Import, m.Subpath
+					}
+					if match != test.match || subpath != test.subpath {
+						t.Errorf("%s: %s: match = %q, %q; want %q, %q", test.importPath, name, match, subpath, test.match, test.subpath)
+					}
+					next.ServeHTTP(w, r)
+				})
+			}
+		}
+		h := vanitytest.NewHandler(t, config,
+			vanity.WithMiddleware(mw("first"), mw("second")),
+			vanity.WithMiddleware(mw("third")),
+		)
+		vanitytest.GoGet(t, h, test.importPath)
+		if want := []string{"first", "second", "third"}; !reflect.DeepEqual(calls, want) {
+			t.Errorf("%s: middleware called in order %q; want %q", test.importPath, calls, want)
+		}
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		})
+	}
+	h := vanitytest.NewHandler(t, `
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+`, vanity.WithMiddleware(deny))
+	resp := vanitytest.GoGet(t, h, "example.com/portmidi")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d; want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if len(resp.GoImport) > 0 {
+		t.Errorf("go-import = %q; want none", resp.GoImport)
+	}
+}
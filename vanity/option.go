@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"html/template"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// An Option changes how a Handler serves its paths.
+type Option func(*Handler)
+
+// WithCacheMaxAge has responses cached by browsers and proxies for up to d,
+// with a Cache-Control header. Without it, responses carry no such
+// header.
+func WithCacheMaxAge(d time.Duration) Option {
+	return func(h *Handler) {
+		h.cacheControl = "public, max-age=" + strconv.FormatInt(int64(d/time.Second), 10)
+	}
+}
+
+// WithDocsURL sends browsers to docsURL(importPath) for the documentation
+// of a package, in place of the config's docs_url.
+func WithDocsURL(docsURL func(importPath string) string) Option {
+	return func(h *Handler) {
+		h.docsURLFunc = docsURL
+	}
+}
+
+// WithTemplate renders the page for each path with t, in place of the
+// config's vanity_template. It is given the following fields:
+//
+//   - Import: the import path, like "example.com/portmidi"
+//   - Subpath: the rest of the requested path, like "sub/pkg"
+//   - VCS: the version control system, like "git"
+//   - Repo: the URL of the repo
+//   - Display: the go-source content, without the import path
+//   - Redirect: where to send browsers, or empty for the go command
+//   - Canonical: the URL of the package's documentation
+//   - Install: for browsers when install instructions are turned on, the
+//     .Package, its .Description, and its .DocsURL and .SourceURL
+//   - Data: the path's data from the config
+func WithTemplate(t *template.Template) Option {
+	return func(h *Handler) {
+		h.vanityTmpl = t
+	}
+}
+
+// WithIndexTemplate renders the index page with t, in place of the
+// config's index_template. It is given .Host and a .Paths list whose
+// entries have .Import, .Description, .DocsURL, and .Data fields.
+func WithIndexTemplate(t *template.Template) Option {
+	return func(h *Handler) {
+		h.indexTmpl = t
+	}
+}
+
+// WithFuncs adds funcs to those that the templates named in the config
+// can call, such as to link to internal systems. Templates given to
+// WithTemplate and WithIndexTemplate are parsed by the caller, who adds
+// their functions then.
+func WithFuncs(funcs template.FuncMap) Option {
+	return func(h *Handler) {
+		if h.funcs == nil {
+			h.funcs = make(template.FuncMap)
+		}
+		for name, f := range funcs {
+			h.funcs[name] = f
+		}
+	}
+}
+
+// WithLogger logs errors, like templates that fail to render, to logger
+// rather than to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *Handler) {
+		h.logger = logger
+	}
+}
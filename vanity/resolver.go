@@ -0,0 +1,58 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Resolver returns the settings for an import path, like
+// "example.com/portmidi", that isn't in the config. It returns nil if
+// nothing is served there.
+type Resolver func(importPath string) (*PathConfig, error)
+
+// WithResolver has paths that aren't in the config looked up with r, such
+// as in a database. For a request for example.com/a/b, r is asked for
+// example.com/a/b and then example.com/a, and the first settings it
+// returns are served, with the rest of the request's path as the subpath.
+// r is called for every such request, so it should cache its answers if
+// they are slow to find. Unless the Config gives a Host, the host in the
+// import path comes from the request, and may be anything.
+func WithResolver(r Resolver) Option {
+	return func(h *Handler) {
+		h.resolver = r
+	}
+}
+
+// resolve asks the resolver for the path that current falls under, in the
+// same way as find.
+func (h *Handler) resolve(host, current string) (*path, string, error) {
+	for p := strings.TrimSuffix(current, "/"); strings.HasPrefix(p, "/"); p = p[:strings.LastIndex(p, "/")] {
+		e, err := h.resolver(host + p)
+		if err != nil {
+			return nil, "", fmt.Errorf("resolving %s: %v", host+p, err)
+		}
+		if e == nil {
+			continue
+		}
+		pc, err := h.newPath(p, *e)
+		if err != nil {
+			return nil, "", fmt.Errorf("resolving %s: %v", host+p, err)
+		}
+		return pc, strings.TrimPrefix(strings.TrimPrefix(current, p), "/"), nil
+	}
+	return nil, "", nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+	"github.com/GoogleCloudPlatform/govanityurls/vanity/vanitytest"
+)
+
+func TestResolver(t *testing.T) {
+	const config = `
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+`
+	tests := []struct {
+		importPath string
+		// want is the content of the go-import tag, or empty if the
+		// path isn't served.
+		want string
+		// asked lists the import paths the resolver is asked about.
+		asked []string
+	}{
+		{
+			importPath: "example.com/portmidi/sub",
+			want:       "example.com/portmidi git https://github.com/rakyll/portmidi",
+		},
+		{
+			importPath: "example.com/customer/acme",
+			want:       "example.com/customer/acme git https://git.example.com/acme",
+			asked:      []string{"example.com/customer/acme"},
+		},
+		{
+			importPath: "example.com/customer/acme/sub/pkg",
+			want:       "example.com/customer/acme git https://git.example.com/acme",
+			asked:      []string{"example.com/customer/acme/sub/pkg", "example.com/customer/acme/sub", "example.com/customer/acme"},
+		},
+		{
+			importPath: "example.com/unknown/pkg",
+			asked:      []string{"example.com/unknown/pkg", "example.com/unknown"},
+		},
+	}
+	for _, test := range tests {
+		var asked []string
+		h := vanitytest.NewHandler(t, config, vanity.WithResolver(func(importPath string) (*vanity.PathConfig, error) {
+			asked = append(asked, importPath)
+			if importPath == "example.com/customer/acme" {
+				return &vanity.PathConfig{Repo: "https://git.example.com/acme"}, nil
+			}
+			return nil, nil
+		}))
+		if test.want == "" {
+			vanitytest.AssertNotFound(t, h, test.importPath)
+		} else {
+			vanitytest.AssertGoImport(t, h, test.importPath, test.want)
+		}
+		if !reflect.DeepEqual(asked, test.asked) {
+			t.Errorf("%s: resolver asked about %q; want %q", test.importPath, asked, test.asked)
+		}
+	}
+}
+
+func TestResolverRequestHost(t *testing.T) {
+	var asked []string
+	h := vanitytest.NewHandler(t, "", vanity.WithResolver(func(importPath string) (*vanity.PathConfig, error) {
+		asked = append(asked, importPath)
+		return &vanity.PathConfig{Repo: "https://git.example.com/acme"}, nil
+	}))
+	// The port the client connected to isn't part of the import path.
+	vanitytest.AssertGoImport(t, h, "example.com:8080/acme", "example.com/acme git https://git.example.com/acme")
+	if want := []string{"example.com/acme"}; !reflect.DeepEqual(asked, want) {
+		t.Errorf("resolver asked about %q; want %q", asked, want)
+	}
+
+	// With a host in the config, the request's is never used.
+	asked = nil
+	h = vanitytest.NewHandler(t, "host: go.example.com", vanity.WithResolver(func(importPath string) (*vanity.PathConfig, error) {
+		asked = append(asked, importPath)
+		return &vanity.PathConfig{Repo: "https://git.example.com/acme"}, nil
+	}))
+	vanitytest.AssertGoImport(t, h, "evil.example.com/acme", "go.example.com/acme git https://git.example.com/acme")
+	if want := []string{"go.example.com/acme"}; !reflect.DeepEqual(asked, want) {
+		t.Errorf("with host: resolver asked about %q; want %q", asked, want)
+	}
+}
+
+func TestResolverNoSlash(t *testing.T) {
+	called := false
+	h := vanitytest.NewHandler(t, "", vanity.WithResolver(func(importPath string) (*vanity.PathConfig, error) {
+		called = true
+		return nil, nil
+	}))
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	req.URL.Path, req.RequestURI = "*", "*"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET *: status = %d; want %d", rec.Code, http.StatusNotFound)
+	}
+	if called {
+		t.Error("GET *: resolver was called")
+	}
+}
+
+func TestResolverError(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry *vanity.PathConfig
+		err   error
+	}{
+		{name: "error", err: errors.New("database is down")},
+		{name: "invalid entry", entry: &vanity.PathConfig{Repo: "https://git.example.com/acme", VCS: "cvs"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := vanitytest.NewHandler(t, "", vanity.WithResolver(func(importPath string) (*vanity.PathConfig, error) {
+				return test.entry, test.err
+			}))
+			resp := vanitytest.GoGet(t, h, "example.com/acme")
+			if resp.StatusCode != http.StatusInternalServerError {
+				t.Errorf("status = %d; want %d", resp.StatusCode, http.StatusInternalServerError)
+			}
+		})
+	}
+}
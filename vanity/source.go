@@ -0,0 +1,239 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// A ConfigSource loads a config in YAML, like a govanityurls config file,
+// from wherever it is kept. The sources in this package read a file, an
+// environment variable, or a URL; others can be written for secret stores,
+// databases, and so on.
+type ConfigSource interface {
+	Load(ctx context.Context) ([]byte, error)
+}
+
+// A Watcher is a ConfigSource that can tell when its config changes.
+type Watcher interface {
+	ConfigSource
+	// Watch calls changed each time the config may have changed, until
+	// ctx is done or it can no longer watch. It returns the reason it
+	// stopped.
+	Watch(ctx context.Context, changed func()) error
+}
+
+// maxConfigBytes caps the size of a config fetched over HTTP.
+const maxConfigBytes = 10 << 20
+
+// FileSource reads the config from the named file. It watches the file by
+// checking its modification time every five seconds.
+type FileSource string
+
+// Load reads the file.
+func (f FileSource) Load(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(string(f))
+}
+
+// Watch calls changed whenever the file's modification time or size
+// changes.
+func (f FileSource) Watch(ctx context.Context, changed func()) error {
+	fi, err := os.Stat(string(f))
+	if err != nil {
+		return err
+	}
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		next, err := os.Stat(string(f))
+		if err != nil {
+			// The file may be midway through being replaced.
+			continue
+		}
+		if !next.ModTime().Equal(fi.ModTime()) || next.Size() != fi.Size() {
+			fi = next
+			changed()
+		}
+	}
+}
+
+// EnvSource reads the config from the named environment variable, which
+// must be set.
+type EnvSource string
+
+// Load reads the environment variable.
+func (e EnvSource) Load(ctx context.Context) ([]byte, error) {
+	v, ok := os.LookupEnv(string(e))
+	if !ok {
+		return nil, fmt.Errorf("%s is not set", string(e))
+	}
+	return []byte(v), nil
+}
+
+// HTTPSource fetches the config from a URL.
+type HTTPSource struct {
+	URL string
+	// Client makes the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Interval is how often Watch fetches the config. It defaults to a
+	// minute.
+	Interval time.Duration
+	// Logger logs failed fetches during Watch. If nil, slog.Default() is
+	// used.
+	Logger *slog.Logger
+}
+
+// Load fetches the config.
+func (s *HTTPSource) Load(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", s.URL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %v", s.URL, err)
+	}
+	if len(data) > maxConfigBytes {
+		return nil, fmt.Errorf("GET %s: config is larger than %d bytes", s.URL, maxConfigBytes)
+	}
+	return data, nil
+}
+
+// Watch fetches the config every Interval, calling changed when it
+// differs from the last fetch. Failed fetches are logged and skipped.
+func (s *HTTPSource) Watch(ctx context.Context, changed func()) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	last, err := s.Load(ctx)
+	if err != nil {
+		return err
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		data, err := s.Load(ctx)
+		if err != nil {
+			logger := s.Logger
+			if logger == nil {
+				logger = slog.Default()
+			}
+			logger.Warn("cannot fetch config", "url", s.URL, "err", err)
+			continue
+		}
+		if !bytes.Equal(data, last) {
+			last = data
+			changed()
+		}
+	}
+}
+
+// Load returns a handler for the config loaded from src.
+func Load(ctx context.Context, src ConfigSource, opts ...Option) (*Handler, error) {
+	data, err := src.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return New(*c, opts...)
+}
+
+// A Reloader serves the paths of the config in a ConfigSource, swapping in
+// a new Handler each time it is reloaded.
+type Reloader struct {
+	src  ConfigSource
+	opts []Option
+	h    atomic.Pointer[Handler]
+}
+
+// NewReloader returns a Reloader serving the config loaded from src, with
+// opts applied to each Handler it builds.
+func NewReloader(ctx context.Context, src ConfigSource, opts ...Option) (*Reloader, error) {
+	r := &Reloader{src: src, opts: opts}
+	if err := r.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the config again. If it can't be loaded or is invalid, the
+// previous config continues to be served.
+func (r *Reloader) Reload(ctx context.Context) error {
+	h, err := Load(ctx, r.src, r.opts...)
+	if err != nil {
+		return err
+	}
+	r.h.Store(h)
+	return nil
+}
+
+// Watch reloads the config whenever its source reports a change, until
+// ctx is done. Errors from reloading are logged with the current
+// Handler's logger. The source must be a Watcher.
+func (r *Reloader) Watch(ctx context.Context) error {
+	w, ok := r.src.(Watcher)
+	if !ok {
+		return fmt.Errorf("vanity: %T cannot be watched", r.src)
+	}
+	return w.Watch(ctx, func() {
+		if err := r.Reload(ctx); err != nil {
+			r.h.Load().logger.Error("cannot reload config", "err", err)
+		}
+	})
+}
+
+// Handler returns the Handler currently in use.
+func (r *Reloader) Handler() *Handler {
+	return r.h.Load()
+}
+
+// ServeHTTP serves the request with the current Handler.
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.h.Load().ServeHTTP(w, req)
+}
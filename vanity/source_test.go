@@ -0,0 +1,155 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+	"github.com/GoogleCloudPlatform/govanityurls/vanity/vanitytest"
+)
+
+// memSource is a ConfigSource holding the config in memory, which
+// reports each change to it when watched.
+type memSource struct {
+	mu      sync.Mutex
+	config  string
+	err     error
+	changed chan struct{}
+}
+
+func newMemSource(config string) *memSource {
+	return &memSource{config: config, changed: make(chan struct{}, 1)}
+}
+
+func (s *memSource) Load(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return []byte(s.config), s.err
+}
+
+func (s *memSource) set(config string, err error) {
+	s.mu.Lock()
+	s.config, s.err = config, err
+	s.mu.Unlock()
+}
+
+func (s *memSource) Watch(ctx context.Context, changed func()) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.changed:
+			changed()
+		}
+	}
+}
+
+const (
+	portmidiConfig = `
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+`
+	launchpadConfig = `
+paths:
+  /launchpad:
+    repo: https://github.com/rakyll/launchpad
+`
+)
+
+func TestReloader(t *testing.T) {
+	ctx := context.Background()
+	src := newMemSource(portmidiConfig)
+	r, err := vanity.NewReloader(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vanitytest.AssertGoImport(t, r, "example.com/portmidi", "example.com/portmidi git https://github.com/rakyll/portmidi")
+	vanitytest.AssertNotFound(t, r, "example.com/launchpad")
+
+	steps := []struct {
+		name   string
+		config string
+		err    error
+		// wantErr is set if Reload should fail, keeping launchpadConfig.
+		wantErr bool
+	}{
+		{name: "changed", config: launchpadConfig},
+		{name: "invalid", config: "paths:\n  /portmidi: {}\n", wantErr: true},
+		{name: "unparsable", config: "paths: [", wantErr: true},
+		{name: "load error", err: errors.New("unavailable"), wantErr: true},
+	}
+	for _, step := range steps {
+		src.set(step.config, step.err)
+		err := r.Reload(ctx)
+		if gotErr := err != nil; gotErr != step.wantErr {
+			t.Errorf("%s: Reload(...) error = %v; want error = %t", step.name, err, step.wantErr)
+		}
+		vanitytest.AssertGoImport(t, r, "example.com/launchpad", "example.com/launchpad git https://github.com/rakyll/launchpad")
+		vanitytest.AssertNotFound(t, r, "example.com/portmidi")
+	}
+}
+
+func TestNewReloaderError(t *testing.T) {
+	src := newMemSource("paths:\n  portmidi:\n    repo: https://github.com/rakyll/portmidi\n")
+	if _, err := vanity.NewReloader(context.Background(), src); err == nil {
+		t.Error("NewReloader(...) succeeded with an invalid config")
+	}
+}
+
+func TestReloaderWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := newMemSource(portmidiConfig)
+	r, err := vanity.NewReloader(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- r.Watch(ctx) }()
+	old := r.Handler()
+	src.set(launchpadConfig, nil)
+	src.changed <- struct{}{}
+	for deadline := time.Now().Add(5 * time.Second); r.Handler() == old; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("config not reloaded after the source changed")
+		}
+	}
+	vanitytest.AssertGoImport(t, r, "example.com/launchpad", "example.com/launchpad git https://github.com/rakyll/launchpad")
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Watch(...) = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestReloaderWatchUnwatchable(t *testing.T) {
+	r, err := vanity.NewReloader(context.Background(), vanity.EnvSource("VANITY_TEST_CONFIG"))
+	if err == nil {
+		t.Fatalf("NewReloader(...) = %v; want error for unset variable", r)
+	}
+	t.Setenv("VANITY_TEST_CONFIG", portmidiConfig)
+	r, err = vanity.NewReloader(context.Background(), vanity.EnvSource("VANITY_TEST_CONFIG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Watch(context.Background()); err == nil {
+		t.Error("Watch(...) succeeded for a source that can't be watched")
+	}
+}
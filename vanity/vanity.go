@@ -0,0 +1,372 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vanity serves vanity import paths for Go packages, for embedding
+// in other servers. It serves the meta tags the go command looks for at
+// each configured path, sends browsers on to the package's documentation,
+// and lists the paths on an index page.
+//
+// The govanityurls app adds operational features on top, like TLS,
+// discovery of repos, and management endpoints.
+package vanity
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/GoogleCloudPlatform/govanityurls/internal/codehost"
+	"github.com/GoogleCloudPlatform/govanityurls/internal/vanitypage"
+	"gopkg.in/yaml.v2"
+)
+
+// Config describes the paths served by a Handler. It has the same form as
+// the govanityurls config file, of which it is a subset.
+type Config struct {
+	// Host is the host name import paths are built from. If empty, the
+	// host of each request is used, without its port. That is whatever
+	// the client sent, so set Host if paths are looked up with a Resolver
+	// that shouldn't be asked about arbitrary hosts.
+	Host string `yaml:"host,omitempty"`
+
+	// DocsURL is a text/template for the URL of a package's
+	// documentation, given its import path as .Import. It defaults to
+	// https://pkg.go.dev/{{.Import}}.
+	DocsURL string `yaml:"docs_url,omitempty"`
+
+	// VanityTemplate and IndexTemplate name HTML template files that
+	// replace the page for each path and the index page. They can call
+	// the functions given to WithFuncs.
+	VanityTemplate string `yaml:"vanity_template,omitempty"`
+	IndexTemplate  string `yaml:"index_template,omitempty"`
+
+	// DefaultBranch is the branch source links point at for paths that
+	// don't give one. It defaults to master.
+	DefaultBranch string `yaml:"default_branch,omitempty"`
+	// DetectBranch, if set, has New ask GitHub, GitLab, and Bitbucket for
+	// the default branch of each repo in Paths that doesn't give one.
+	// BranchCache names a file the answers are kept in, if any.
+	DetectBranch bool   `yaml:"detect_branch,omitempty"`
+	BranchCache  string `yaml:"branch_cache,omitempty"`
+
+	// GitHubHosts lists the hostnames of GitHub Enterprise servers, whose
+	// repos get source links like those on github.com, and GitHubTokens
+	// maps them to the API tokens used to detect branches.
+	// BitbucketServerHosts lists those of Bitbucket Server instances.
+	GitHubHosts          []string          `yaml:"github_hosts,omitempty"`
+	GitHubTokens         map[string]string `yaml:"github_tokens,omitempty"`
+	BitbucketServerHosts []string          `yaml:"bitbucket_server_hosts,omitempty"`
+
+	// RedirectMode is how browsers are redirected for paths that don't
+	// say: "meta", the default, with a meta refresh tag in the page, or
+	// "http", with a 302 redirect.
+	RedirectMode string `yaml:"redirect_mode,omitempty"`
+	// InstallInstructions, if set, shows browsers a page with the
+	// commands to install the package, its description, and links to its
+	// documentation and source, rather than redirecting them.
+	InstallInstructions bool `yaml:"install_instructions,omitempty"`
+
+	// Paths maps each path, like "/portmidi", to the repo served there.
+	Paths map[string]PathConfig `yaml:"paths,omitempty"`
+}
+
+// PathConfig describes the repo served at a path.
+type PathConfig struct {
+	// Repo is the URL of the repo. Shorthands like "gh:user/repo" and
+	// "github.com/user/repo" are expanded, and SSH URLs are served as
+	// their HTTPS equivalent.
+	Repo string `yaml:"repo,omitempty"`
+	// VCS is the version control system of the repo: "bzr", "fossil",
+	// "git", "hg", "mod", or "svn". It defaults to git.
+	VCS string `yaml:"vcs,omitempty"`
+	// Display is the content of the go-source meta tag, without the
+	// import prefix. It is filled in for repos on GitHub, Bitbucket, and
+	// Launchpad, and on the servers named in the config, if left empty.
+	Display string `yaml:"display,omitempty"`
+	// Branch is the branch source links point at. It defaults to the
+	// detected default branch, or else to the config's DefaultBranch.
+	Branch string `yaml:"branch,omitempty"`
+	// Redirect is where browsers are sent: "docs", the default, "repo",
+	// or a URL.
+	Redirect string `yaml:"redirect,omitempty"`
+	// RedirectMode is how browsers are redirected: "meta" or "http". It
+	// defaults to the config's RedirectMode.
+	RedirectMode string `yaml:"redirect_mode,omitempty"`
+	// Description is shown on the index page, and on the page for the
+	// path when install instructions are turned on.
+	Description string `yaml:"description,omitempty"`
+	// Data holds values of the config's choosing for custom templates,
+	// like a build badge URL.
+	Data map[string]string `yaml:"data,omitempty"`
+}
+
+// ParseConfig parses a config in YAML, such as a govanityurls config file.
+// Settings that this package doesn't use are ignored. Paths given at the
+// top level of the config, as in old config files, are served along with
+// those under paths:. Configs that serve several hosts under hosts: are
+// rejected, since a Handler serves only one; give each host its own
+// Config instead.
+func ParseConfig(data []byte) (*Config, error) {
+	c := new(Config)
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	var multi struct {
+		Hosts interface{} `yaml:"hosts"`
+	}
+	if err := yaml.Unmarshal(data, &multi); err != nil {
+		return nil, err
+	}
+	if multi.Hosts != nil {
+		return nil, errors.New("hosts: is not supported; give each host its own Config")
+	}
+	legacy, err := vanitypage.LegacyPaths[PathConfig](data)
+	if err != nil {
+		return nil, err
+	}
+	for path, e := range legacy {
+		if _, ok := c.Paths[path]; ok {
+			return nil, fmt.Errorf("%s: path is given both at the top level and under paths:", path)
+		}
+		if c.Paths == nil {
+			c.Paths = make(map[string]PathConfig, len(legacy))
+		}
+		c.Paths[path] = e
+	}
+	return c, nil
+}
+
+// Handler serves the paths of a Config. Create one with New.
+type Handler struct {
+	host    string
+	docsURL *texttemplate.Template
+	// docsURLFunc, if set, is used in place of docsURL.
+	docsURLFunc func(importPath string) string
+
+	funcs        template.FuncMap
+	vanityTmpl   *template.Template
+	indexTmpl    *template.Template
+	cacheControl string
+	resolver     Resolver
+	logger       *slog.Logger
+	// hosts recognizes the repos of self-hosted code hosts, and
+	// defaultBranch is the branch of paths that don't give one.
+	hosts         codehost.Hosts
+	defaultBranch string
+	// redirectMode is the redirect mode of paths that don't give one, and
+	// installInstructions is set to show browsers how to install a path's
+	// package rather than redirecting them.
+	redirectMode        string
+	installInstructions bool
+	notFound            http.Handler
+	middleware          []func(http.Handler) http.Handler
+	// serve is serveMatched, wrapped in the middleware.
+	serve http.Handler
+
+	// paths maps each path, without a trailing slash, to its settings.
+	paths map[string]*path
+}
+
+// New returns a handler for the paths of c, after checking them. The
+// options are applied in order.
+func New(c Config, opts ...Option) (*Handler, error) {
+	h := &Handler{
+		host:   c.Host,
+		logger: slog.Default(),
+		hosts: codehost.Hosts{
+			GitHub:          c.GitHubHosts,
+			GitHubTokens:    c.GitHubTokens,
+			BitbucketServer: c.BitbucketServerHosts,
+		},
+		defaultBranch:       c.DefaultBranch,
+		redirectMode:        c.RedirectMode,
+		installInstructions: c.InstallInstructions,
+		paths:               make(map[string]*path),
+	}
+	if h.defaultBranch == "" {
+		h.defaultBranch = "master"
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	docsURL := c.DocsURL
+	if docsURL == "" {
+		docsURL = "https://pkg.go.dev/{{.Import}}"
+	}
+	var err error
+	h.docsURL, err = texttemplate.New("docs_url").Parse(docsURL)
+	if err != nil {
+		return nil, fmt.Errorf("docs_url: %v", err)
+	}
+	var detected map[string]string
+	if c.DetectBranch {
+		var repos []string
+		for _, e := range c.Paths {
+			if e.Branch == "" {
+				_, web := h.repoURLs(e.Repo)
+				repos = append(repos, web)
+			}
+		}
+		detected = h.hosts.DetectDefaultBranches(repos, c.BranchCache, h.logger)
+	}
+	for path, e := range c.Paths {
+		if e.Branch == "" {
+			_, web := h.repoURLs(e.Repo)
+			e.Branch = detected[web]
+		}
+		p, err := h.newPath(path, e)
+		if err != nil {
+			return nil, err
+		}
+		h.paths[p.path] = p
+	}
+	if h.vanityTmpl == nil {
+		h.vanityTmpl, err = h.parseTemplate(c.VanityTemplate, vanitypage.Template)
+		if err != nil {
+			return nil, fmt.Errorf("vanity_template: %v", err)
+		}
+	}
+	if h.indexTmpl == nil {
+		h.indexTmpl, err = h.parseTemplate(c.IndexTemplate, indexTmpl)
+		if err != nil {
+			return nil, fmt.Errorf("index_template: %v", err)
+		}
+	}
+	h.serve = http.HandlerFunc(h.serveMatched)
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		h.serve = h.middleware[i](h.serve)
+	}
+	return h, nil
+}
+
+// parseTemplate parses the named template file with the handler's
+// functions, or returns def if there is none.
+func (h *Handler) parseTemplate(file string, def *template.Template) (*template.Template, error) {
+	if file == "" {
+		return def, nil
+	}
+	return template.New(filepath.Base(file)).Funcs(h.funcs).ParseFiles(file)
+}
+
+// path is a configured path, with its settings filled in.
+type path struct {
+	path         string
+	repo         string
+	vcs          string
+	display      string
+	redirect     string
+	redirectMode string
+	description  string
+	data         map[string]string
+	// web is the HTTPS URL of the repo, used for source links.
+	web string
+}
+
+// repoURLs expands repo, and returns the URL it is served as along with
+// its web URL, which source links are built from. SSH URLs are served as
+// their HTTPS equivalent, and Bitbucket Server repos by their clone URL.
+func (h *Handler) repoURLs(repo string) (served, web string) {
+	served = codehost.Expand(repo)
+	web = served
+	if w, _, ok := codehost.ParseSSH(served); ok {
+		served, web = w, w
+	}
+	if clone, home, ok := h.hosts.ParseBitbucketServer(web); ok {
+		served, web = clone, home
+	}
+	return served, web
+}
+
+// newPath checks the entry e for p, and fills in the settings it leaves
+// to the defaults.
+func (h *Handler) newPath(p string, e PathConfig) (*path, error) {
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("%s: path must start with a slash", p)
+	}
+	if e.Repo == "" {
+		return nil, fmt.Errorf("%s: repo is required", p)
+	}
+	pc := &path{
+		path:         strings.TrimSuffix(p, "/"),
+		vcs:          e.VCS,
+		display:      e.Display,
+		redirect:     e.Redirect,
+		redirectMode: e.RedirectMode,
+		description:  e.Description,
+		data:         e.Data,
+	}
+	pc.repo, pc.web = h.repoURLs(e.Repo)
+	switch pc.vcs {
+	case "":
+		if codehost.IsLaunchpad(pc.web) {
+			pc.vcs = "bzr"
+		} else {
+			pc.vcs = "git"
+		}
+	case "bzr", "fossil", "git", "hg", "mod", "svn":
+	default:
+		return nil, fmt.Errorf("%s: unknown VCS %q", p, pc.vcs)
+	}
+	switch pc.redirect {
+	case "":
+		pc.redirect = "docs"
+	case "docs", "repo":
+	default:
+		if u, err := url.Parse(pc.redirect); err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("%s: redirect must be docs, repo, or an absolute URL", p)
+		}
+	}
+	if pc.redirectMode == "" {
+		pc.redirectMode = h.redirectMode
+	}
+	switch pc.redirectMode {
+	case "":
+		pc.redirectMode = "meta"
+	case "meta", "http":
+	default:
+		return nil, fmt.Errorf("%s: redirect_mode must be meta or http", p)
+	}
+	if pc.display == "" {
+		branch := e.Branch
+		if branch == "" {
+			branch = h.defaultBranch
+		}
+		pc.display = h.hosts.Display(pc.web, pc.vcs, branch)
+	}
+	return pc, nil
+}
+
+// find returns the path that current falls under, along with the rest of
+// current (without a leading slash) as the subpath, or nil if there is
+// none. A path at the root of the host matches every request whose path
+// starts with a slash.
+func (h *Handler) find(current string) (*path, string) {
+	p, subpath, ok := vanitypage.Find(current, func(p string) bool {
+		_, ok := h.paths[strings.TrimSuffix(p, "/")]
+		return ok
+	})
+	if ok {
+		return h.paths[strings.TrimSuffix(p, "/")], subpath
+	}
+	if root, ok := h.paths[""]; ok && strings.HasPrefix(current, "/") {
+		return root, strings.TrimPrefix(current, "/")
+	}
+	return nil, ""
+}
@@ -0,0 +1,340 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanity_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+	"github.com/GoogleCloudPlatform/govanityurls/vanity/vanitytest"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  vanity.Config
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			config: vanity.Config{Paths: map[string]vanity.PathConfig{
+				"/portmidi": {Repo: "https://github.com/rakyll/portmidi"},
+				"/mod":      {Repo: "https://example.com/mod", VCS: "mod", Redirect: "https://example.com/"},
+			}},
+		},
+		{
+			name:   "no paths",
+			config: vanity.Config{},
+		},
+		{
+			name: "no repo",
+			config: vanity.Config{Paths: map[string]vanity.PathConfig{
+				"/portmidi": {},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "no leading slash",
+			config: vanity.Config{Paths: map[string]vanity.PathConfig{
+				"portmidi": {Repo: "https://github.com/rakyll/portmidi"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "unknown VCS",
+			config: vanity.Config{Paths: map[string]vanity.PathConfig{
+				"/portmidi": {Repo: "https://github.com/rakyll/portmidi", VCS: "cvs"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "relative redirect",
+			config: vanity.Config{Paths: map[string]vanity.PathConfig{
+				"/portmidi": {Repo: "https://github.com/rakyll/portmidi", Redirect: "/elsewhere"},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "bad docs_url",
+			config:  vanity.Config{DocsURL: "https://pkg.go.dev/{{.Import"},
+			wantErr: true,
+		},
+		{
+			name:    "missing vanity_template",
+			config:  vanity.Config{VanityTemplate: "testdata/missing.html"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := vanity.New(test.config)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("New(...) error = %v; want error = %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	h := vanitytest.NewHandler(t, `
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+  /nested/mod:
+    repo: https://github.com/rakyll/nested
+  /trailing/:
+    repo: https://github.com/rakyll/trailing
+`)
+	tests := []struct {
+		importPath string
+		// want is the content of the go-import tag, or empty if the
+		// path isn't served.
+		want string
+	}{
+		{"example.com/portmidi", "example.com/portmidi git https://github.com/rakyll/portmidi"},
+		{"example.com/portmidi/", "example.com/portmidi git https://github.com/rakyll/portmidi"},
+		{"example.com/portmidi/sub/pkg", "example.com/portmidi git https://github.com/rakyll/portmidi"},
+		{"example.com/nested/mod", "example.com/nested/mod git https://github.com/rakyll/nested"},
+		{"example.com/nested/mod/sub", "example.com/nested/mod git https://github.com/rakyll/nested"},
+		{"example.com/trailing", "example.com/trailing git https://github.com/rakyll/trailing"},
+		{"example.com/nested", ""},
+		{"example.com/portmidix", ""},
+		{"example.com/other/portmidi", ""},
+	}
+	for _, test := range tests {
+		if test.want == "" {
+			vanitytest.AssertNotFound(t, h, test.importPath)
+		} else {
+			vanitytest.AssertGoImport(t, h, test.importPath, test.want)
+		}
+	}
+}
+
+func TestRepos(t *testing.T) {
+	h := vanitytest.NewHandler(t, `
+default_branch: main
+github_hosts: [github.example.com]
+bitbucket_server_hosts: [bitbucket.example.com]
+paths:
+  /short:
+    repo: gh:rakyll/short
+  /ssh:
+    repo: git@github.com:rakyll/ssh.git
+  /branch:
+    repo: https://github.com/rakyll/branch
+    branch: develop
+  /ghe:
+    repo: https://github.example.com/team/ghe
+  /bbs:
+    repo: https://bitbucket.example.com/scm/team/bbs.git
+  /bb:
+    repo: https://bitbucket.org/rakyll/bb
+  /lp:
+    repo: https://launchpad.net/lp
+  /other:
+    repo: https://git.example.com/other
+    display: https://git.example.com/other _ _
+`)
+	tests := []struct {
+		importPath string
+		goImport   string
+		goSource   string
+	}{
+		{
+			"example.com/short",
+			"example.com/short git https://github.com/rakyll/short",
+			"example.com/short https://github.com/rakyll/short https://github.com/rakyll/short/tree/main{/dir} https://github.com/rakyll/short/blob/main{/dir}/{file}#L{line}",
+		},
+		{
+			"example.com/ssh",
+			"example.com/ssh git https://github.com/rakyll/ssh",
+			"example.com/ssh https://github.com/rakyll/ssh https://github.com/rakyll/ssh/tree/main{/dir} https://github.com/rakyll/ssh/blob/main{/dir}/{file}#L{line}",
+		},
+		{
+			"example.com/branch",
+			"example.com/branch git https://github.com/rakyll/branch",
+			"example.com/branch https://github.com/rakyll/branch https://github.com/rakyll/branch/tree/develop{/dir} https://github.com/rakyll/branch/blob/develop{/dir}/{file}#L{line}",
+		},
+		{
+			"example.com/ghe",
+			"example.com/ghe git https://github.example.com/team/ghe",
+			"example.com/ghe https://github.example.com/team/ghe https://github.example.com/team/ghe/tree/main{/dir} https://github.example.com/team/ghe/blob/main{/dir}/{file}#L{line}",
+		},
+		{
+			"example.com/bbs",
+			"example.com/bbs git https://bitbucket.example.com/scm/team/bbs.git",
+			"example.com/bbs https://bitbucket.example.com/projects/TEAM/repos/bbs https://bitbucket.example.com/projects/TEAM/repos/bbs/browse{/dir}?at=refs/heads/main https://bitbucket.example.com/projects/TEAM/repos/bbs/browse{/dir}/{file}?at=refs/heads/main#{line}",
+		},
+		{
+			"example.com/bb",
+			"example.com/bb git https://bitbucket.org/rakyll/bb",
+			"example.com/bb https://bitbucket.org/rakyll/bb https://bitbucket.org/rakyll/bb/src/main{/dir} https://bitbucket.org/rakyll/bb/src/main{/dir}/{file}#lines-{line}",
+		},
+		{
+			"example.com/lp",
+			"example.com/lp bzr https://launchpad.net/lp",
+			"example.com/lp https://launchpad.net/lp https://bazaar.launchpad.net/+branch/lp/files/head:{/dir} https://bazaar.launchpad.net/+branch/lp/view/head:{/dir}/{file}#L{line}",
+		},
+		{
+			"example.com/other",
+			"example.com/other git https://git.example.com/other",
+			"example.com/other https://git.example.com/other _ _",
+		},
+	}
+	for _, test := range tests {
+		vanitytest.AssertGoImport(t, h, test.importPath, test.goImport)
+		vanitytest.AssertGoSource(t, h, test.importPath, test.goSource)
+	}
+}
+
+func TestNoSlash(t *testing.T) {
+	h := vanitytest.NewHandler(t, `
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+`)
+	for _, path := range []string{"*", "portmidi", ""} {
+		req := httptest.NewRequest("GET", "https://example.com/", nil)
+		req.URL.Path, req.RequestURI = path, path
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %q: status = %d; want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestParseConfigLegacyPaths(t *testing.T) {
+	h := vanitytest.NewHandler(t, `
+/portmidi:
+  repo: https://github.com/rakyll/portmidi
+paths:
+  /launchpad:
+    repo: https://github.com/rakyll/launchpad
+`)
+	vanitytest.AssertGoImport(t, h, "example.com/portmidi", "example.com/portmidi git https://github.com/rakyll/portmidi")
+	vanitytest.AssertGoImport(t, h, "example.com/launchpad", "example.com/launchpad git https://github.com/rakyll/launchpad")
+
+	_, err := vanity.ParseConfig([]byte(`
+/portmidi:
+  repo: https://github.com/rakyll/portmidi
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/other
+`))
+	if err == nil {
+		t.Error("ParseConfig with /portmidi at the top level and under paths: succeeded; want error")
+	}
+}
+
+func TestParseConfigHosts(t *testing.T) {
+	_, err := vanity.ParseConfig([]byte(`
+hosts:
+  - host: go.example.com
+    paths:
+      /portmidi:
+        repo: https://github.com/rakyll/portmidi
+`))
+	if err == nil {
+		t.Error("ParseConfig with hosts: succeeded; want error")
+	}
+}
+
+func TestBrowserRedirect(t *testing.T) {
+	h := vanitytest.NewHandler(t, `
+redirect_mode: http
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+  /meta:
+    repo: https://github.com/rakyll/meta
+    redirect_mode: meta
+  /repo:
+    repo: https://github.com/rakyll/repo
+    redirect: repo
+`)
+	tests := []struct {
+		url      string
+		code     int
+		location string
+	}{
+		{"https://example.com/portmidi/sub", http.StatusFound, "https://pkg.go.dev/example.com/portmidi/sub"},
+		{"https://example.com/portmidi?tab=versions", http.StatusFound, "https://pkg.go.dev/example.com/portmidi?tab=versions"},
+		{"https://example.com/repo/sub", http.StatusFound, "https://github.com/rakyll/repo"},
+		{"https://example.com/meta", http.StatusOK, ""},
+		{"https://example.com/portmidi?go-get=1", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", test.url, nil))
+		if rec.Code != test.code || rec.Header().Get("Location") != test.location {
+			t.Errorf("GET %s = %d, Location %q; want %d, Location %q", test.url, rec.Code, rec.Header().Get("Location"), test.code, test.location)
+		}
+	}
+
+	_, err := vanity.New(vanity.Config{Paths: map[string]vanity.PathConfig{
+		"/portmidi": {Repo: "https://github.com/rakyll/portmidi", RedirectMode: "js"},
+	}})
+	if err == nil {
+		t.Error("New with redirect_mode js succeeded; want error")
+	}
+}
+
+func TestMetaRedirectKeepsQuery(t *testing.T) {
+	h := vanitytest.NewHandler(t, `
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+`)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "https://example.com/portmidi/sub?tab=doc", nil))
+	const want = `content="0; url=https://pkg.go.dev/example.com/portmidi/sub?tab=doc"`
+	if body := rec.Body.String(); !strings.Contains(body, want) {
+		t.Errorf("page for /portmidi/sub?tab=doc doesn't contain %s:\n%s", want, body)
+	}
+}
+
+func TestInstallInstructions(t *testing.T) {
+	h := vanitytest.NewHandler(t, `
+install_instructions: true
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+    description: Go bindings for PortMidi
+`)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "https://example.com/portmidi/sub", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /portmidi/sub = %d; want %d", rec.Code, http.StatusOK)
+	}
+	for _, want := range []string{
+		"go get example.com/portmidi/sub",
+		"Go bindings for PortMidi",
+		`href="https://pkg.go.dev/example.com/portmidi/sub"`,
+		`href="https://github.com/rakyll/portmidi"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page for /portmidi/sub doesn't contain %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "refresh") {
+		t.Errorf("page for /portmidi/sub redirects with install instructions turned on:\n%s", body)
+	}
+	vanitytest.AssertGoImport(t, h, "example.com/portmidi", "example.com/portmidi git https://github.com/rakyll/portmidi")
+}
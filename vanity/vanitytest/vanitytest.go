@@ -0,0 +1,159 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vanitytest helps test vanity configs and servers that embed the
+// vanity package. Its helpers take the testing.TB of the calling test and
+// fail it on errors.
+package vanitytest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity"
+)
+
+// NewHandler returns a handler for config, a YAML config like the
+// govanityurls config file, failing the test if it is invalid.
+func NewHandler(t testing.TB, config string, opts ...vanity.Option) *vanity.Handler {
+	t.Helper()
+	c, err := vanity.ParseConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("parsing config: %v", err)
+	}
+	h, err := vanity.New(*c, opts...)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	return h
+}
+
+// NewServer starts a server for config, as given to NewHandler, which is
+// closed when the test finishes.
+func NewServer(t testing.TB, config string, opts ...vanity.Option) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(NewHandler(t, config, opts...))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// A Response is the reply to a simulated request from the go command.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// GoImport and GoSource hold the contents of the go-import and
+	// go-source meta tags in the page, in order.
+	GoImport []string
+	GoSource []string
+}
+
+// GoGet sends h the request the go command makes to find the repo of
+// importPath, like "example.com/portmidi/sub", and returns the response.
+func GoGet(t testing.TB, h http.Handler, importPath string) *Response {
+	t.Helper()
+	if strings.Contains(importPath, "://") {
+		t.Fatalf("import path %q must not have a scheme", importPath)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "https://"+importPath+"?go-get=1", nil))
+	resp := &Response{
+		StatusCode: rec.Code,
+		Header:     rec.Header(),
+		Body:       rec.Body.Bytes(),
+	}
+	resp.GoImport = metaContents(bytes.NewReader(resp.Body), "go-import")
+	resp.GoSource = metaContents(bytes.NewReader(resp.Body), "go-source")
+	return resp
+}
+
+// AssertGoImport checks that h serves importPath to the go command with a
+// single go-import meta tag whose content is want, like
+// "example.com/portmidi git https://github.com/rakyll/portmidi".
+func AssertGoImport(t testing.TB, h http.Handler, importPath, want string) {
+	t.Helper()
+	assertMeta(t, GoGet(t, h, importPath), importPath, "go-import", want)
+}
+
+// AssertGoSource checks that h serves importPath to the go command with a
+// single go-source meta tag whose content is want.
+func AssertGoSource(t testing.TB, h http.Handler, importPath, want string) {
+	t.Helper()
+	assertMeta(t, GoGet(t, h, importPath), importPath, "go-source", want)
+}
+
+// AssertNotFound checks that h doesn't serve importPath to the go command.
+func AssertNotFound(t testing.TB, h http.Handler, importPath string) {
+	t.Helper()
+	resp := GoGet(t, h, importPath)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("%s: status = %d; want %d", importPath, resp.StatusCode, http.StatusNotFound)
+	}
+	if len(resp.GoImport) > 0 {
+		t.Errorf("%s: go-import = %q; want none", importPath, resp.GoImport)
+	}
+}
+
+func assertMeta(t testing.TB, resp *Response, importPath, name, want string) {
+	t.Helper()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("%s: status = %d; want %d", importPath, resp.StatusCode, http.StatusOK)
+		return
+	}
+	got := resp.GoImport
+	if name == "go-source" {
+		got = resp.GoSource
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("%s: %s = %q; want [%q]", importPath, name, got, want)
+	}
+}
+
+// metaContents returns the contents of the meta tags called name in the
+// head of the HTML page in r, parsing it as loosely as the go command.
+func metaContents(r io.Reader, name string) []string {
+	d := xml.NewDecoder(r)
+	d.Strict, d.AutoClose, d.Entity = false, xml.HTMLAutoClose, xml.HTMLEntity
+	var contents []string
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return contents
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return contents
+		}
+		start, ok := t.(xml.StartElement)
+		if ok && strings.EqualFold(start.Name.Local, "meta") && attrValue(start.Attr, "name") == name {
+			contents = append(contents, attrValue(start.Attr, "content"))
+		}
+	}
+}
+
+// attrValue returns the value of the attribute called name, or empty if
+// there is none.
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
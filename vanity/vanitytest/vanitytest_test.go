@@ -0,0 +1,192 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vanitytest_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/govanityurls/vanity/vanitytest"
+)
+
+const config = `
+paths:
+  /portmidi:
+    repo: https://github.com/rakyll/portmidi
+`
+
+// fakeTB records the failures of the helpers instead of failing the test.
+type fakeTB struct {
+	testing.TB
+	failures []string
+	fatal    bool
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Errorf(format string, args ...any) {
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+}
+
+func (tb *fakeTB) Fatalf(format string, args ...any) {
+	tb.Errorf(format, args...)
+	tb.fatal = true
+	runtime.Goexit()
+}
+
+// run calls f with tb on a goroutine of its own, so that Fatalf can stop
+// it, and returns once f is done.
+func (tb *fakeTB) run(f func(testing.TB)) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(tb)
+	}()
+	<-done
+}
+
+func TestGoGet(t *testing.T) {
+	h := vanitytest.NewHandler(t, config)
+	tests := []struct {
+		importPath string
+		status     int
+		goImport   []string
+		goSource   []string
+	}{
+		{
+			importPath: "example.com/portmidi/sub",
+			status:     http.StatusOK,
+			goImport:   []string{"example.com/portmidi git https://github.com/rakyll/portmidi"},
+			goSource:   []string{"example.com/portmidi https://github.com/rakyll/portmidi https://github.com/rakyll/portmidi/tree/master{/dir} https://github.com/rakyll/portmidi/blob/master{/dir}/{file}#L{line}"},
+		},
+		{
+			importPath: "example.com/other",
+			status:     http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		resp := vanitytest.GoGet(t, h, test.importPath)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status = %d; want %d", test.importPath, resp.StatusCode, test.status)
+		}
+		if !reflect.DeepEqual(resp.GoImport, test.goImport) {
+			t.Errorf("%s: go-import = %q; want %q", test.importPath, resp.GoImport, test.goImport)
+		}
+		if !reflect.DeepEqual(resp.GoSource, test.goSource) {
+			t.Errorf("%s: go-source = %q; want %q", test.importPath, resp.GoSource, test.goSource)
+		}
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	h := vanitytest.NewHandler(t, config)
+	const (
+		goImport = "example.com/portmidi git https://github.com/rakyll/portmidi"
+		goSource = "example.com/portmidi https://github.com/rakyll/portmidi https://github.com/rakyll/portmidi/tree/master{/dir} https://github.com/rakyll/portmidi/blob/master{/dir}/{file}#L{line}"
+	)
+	tests := []struct {
+		name   string
+		assert func(testing.TB)
+		fail   bool
+	}{
+		{
+			name:   "go-import",
+			assert: func(tb testing.TB) { vanitytest.AssertGoImport(tb, h, "example.com/portmidi", goImport) },
+		},
+		{
+			name: "wrong go-import",
+			assert: func(tb testing.TB) {
+				vanitytest.AssertGoImport(tb, h, "example.com/portmidi", "example.com/portmidi hg https://github.com/rakyll/portmidi")
+			},
+			fail: true,
+		},
+		{
+			name:   "go-import of missing path",
+			assert: func(tb testing.TB) { vanitytest.AssertGoImport(tb, h, "example.com/other", goImport) },
+			fail:   true,
+		},
+		{
+			name:   "go-source",
+			assert: func(tb testing.TB) { vanitytest.AssertGoSource(tb, h, "example.com/portmidi/sub", goSource) },
+		},
+		{
+			name:   "wrong go-source",
+			assert: func(tb testing.TB) { vanitytest.AssertGoSource(tb, h, "example.com/portmidi", goImport) },
+			fail:   true,
+		},
+		{
+			name:   "not found",
+			assert: func(tb testing.TB) { vanitytest.AssertNotFound(tb, h, "example.com/other") },
+		},
+		{
+			name:   "found",
+			assert: func(tb testing.TB) { vanitytest.AssertNotFound(tb, h, "example.com/portmidi") },
+			fail:   true,
+		},
+		{
+			name:   "scheme in import path",
+			assert: func(tb testing.TB) { vanitytest.GoGet(tb, h, "https://example.com/portmidi") },
+			fail:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tb := &fakeTB{TB: t}
+			tb.run(test.assert)
+			if failed := len(tb.failures) > 0; failed != test.fail {
+				t.Errorf("failures = %q; want failure = %t", tb.failures, test.fail)
+			}
+		})
+	}
+}
+
+func TestNewHandlerInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"unparsable", "paths: ["},
+		{"invalid", "paths:\n  /portmidi: {}\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tb := &fakeTB{TB: t}
+			tb.run(func(tb testing.TB) { vanitytest.NewHandler(tb, test.config) })
+			if !tb.fatal {
+				t.Errorf("NewHandler(...) didn't fail the test; failures = %q", tb.failures)
+			}
+		})
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	srv := vanitytest.NewServer(t, config)
+	resp, err := srv.Client().Get(srv.URL + "/portmidi?go-get=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d; want %d\n%s", resp.StatusCode, http.StatusOK, body)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const (
+	// webhookPrefix is the path under which webhooks from code hosts are
+	// received.
+	webhookPrefix = "/-/hooks/"
+	// maxWebhookBodyBytes caps the size of webhook payloads.
+	maxWebhookBodyBytes = 1 << 20
+)
+
+// isWebhook reports whether r is a webhook for a discovery source.
+func isWebhook(r *http.Request) bool {
+	return r.Method == "POST" && strings.HasPrefix(r.URL.Path, webhookPrefix) && hasWebhooks()
+}
+
+// hasWebhooks reports whether any discovery source accepts webhooks.
+func hasWebhooks() bool {
+	for _, h := range hosts {
+		for _, s := range h.discover {
+			if s.webhookSecret != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveWebhook receives webhooks at /-/hooks/github, for repository
+// events, and at /-/hooks/gitlab, for the project events of system hooks,
+// and updates the paths of the discovery sources they are for right away.
+func serveWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, r, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var handled bool
+	switch r.URL.Path {
+	case webhookPrefix + "github":
+		handled, err = githubWebhook(r, body)
+	case webhookPrefix + "gitlab":
+		handled, err = gitlabWebhook(r, body)
+	default:
+		notFound(w, r)
+		return
+	}
+	switch {
+	case err == errWebhookSignature:
+		httpError(w, r, err.Error(), http.StatusUnauthorized)
+	case err != nil:
+		slog.WarnContext(r.Context(), "cannot handle webhook", "path", r.URL.Path, "err", err)
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+	case !handled:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// errWebhookSignature is returned for webhooks that no source's secret
+// verifies.
+var errWebhookSignature = errors.New("invalid webhook signature")
+
+// githubWebhook handles a GitHub webhook, reporting whether it changed
+// anything.
+func githubWebhook(r *http.Request, body []byte) (bool, error) {
+	sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		return false, errWebhookSignature
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false, errWebhookSignature
+	}
+	var event struct {
+		Action     string `json:"action"`
+		Repository struct {
+			Name          string   `json:"name"`
+			FullName      string   `json:"full_name"`
+			HTMLURL       string   `json:"html_url"`
+			Description   string   `json:"description"`
+			Topics        []string `json:"topics"`
+			DefaultBranch string   `json:"default_branch"`
+			Private       bool     `json:"private"`
+			Archived      bool     `json:"archived"`
+			Fork          bool     `json:"fork"`
+			Visibility    string   `json:"visibility"`
+			Owner         struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+		} `json:"repository"`
+		Changes struct {
+			Repository struct {
+				Name struct {
+					From string `json:"from"`
+				} `json:"name"`
+			} `json:"repository"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return false, err
+	}
+	repo := &discoveredRepo{
+		Name:          event.Repository.Name,
+		FullName:      event.Repository.FullName,
+		Subpath:       event.Repository.Name,
+		URL:           event.Repository.HTMLURL,
+		Description:   event.Repository.Description,
+		Topics:        event.Repository.Topics,
+		DefaultBranch: event.Repository.DefaultBranch,
+		Private:       event.Repository.Private || event.Repository.Visibility != "" && event.Repository.Visibility != "public",
+		Archived:      event.Repository.Archived,
+		Fork:          event.Repository.Fork,
+	}
+	// Repos made private or archived are left out by the filters of the
+	// source, and so dropped like deleted ones, unless it serves them.
+	var oldName string
+	switch event.Action {
+	case "deleted":
+		oldName, repo = repo.FullName, nil
+	case "privatized":
+		repo.Private = true
+	case "archived":
+		repo.Archived = true
+	case "renamed":
+		oldName = event.Repository.Owner.Login + "/" + event.Changes.Repository.Name.From
+	}
+	verified := false
+	for _, h := range hosts {
+		for _, s := range h.discover {
+			if s.webhookSecret == "" || s.config.GitHub == "" {
+				continue
+			}
+			mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+			mac.Write(body)
+			if !hmac.Equal(got, mac.Sum(nil)) {
+				continue
+			}
+			verified = true
+			// Pings and other events only need to be verified.
+			if r.Header.Get("X-GitHub-Event") != "repository" || !strings.EqualFold(s.config.GitHub, event.Repository.Owner.Login) {
+				continue
+			}
+			// Events for repos the source doesn't serve, like private
+			// ones, are ignored.
+			changed, err := h.updateRepo(s, oldName, repo)
+			if err != nil || !changed {
+				return false, err
+			}
+			slog.InfoContext(r.Context(), "repo changed", "source", s.name, "action", event.Action, "repo", event.Repository.FullName)
+			return true, nil
+		}
+	}
+	if !verified {
+		return false, errWebhookSignature
+	}
+	return false, nil
+}
+
+// gitlabWebhook handles a GitLab system hook, reporting whether it
+// changed anything.
+func gitlabWebhook(r *http.Request, body []byte) (bool, error) {
+	token := r.Header.Get("X-Gitlab-Token")
+	var event struct {
+		EventName            string `json:"event_name"`
+		PathWithNamespace    string `json:"path_with_namespace"`
+		OldPathWithNamespace string `json:"old_path_with_namespace"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return false, err
+	}
+	verified := false
+	for _, h := range hosts {
+		for _, s := range h.discover {
+			if s.webhookSecret == "" || s.config.GitLab == "" || !secureEqual(token, s.webhookSecret) {
+				continue
+			}
+			verified = true
+			group := strings.Trim(s.config.GitLab, "/")
+			name := event.PathWithNamespace
+			oldName := event.OldPathWithNamespace
+			inGroup := func(name string) bool {
+				return strings.HasPrefix(strings.ToLower(name), strings.ToLower(group)+"/")
+			}
+			if !inGroup(name) && !inGroup(oldName) {
+				continue
+			}
+			var repo *discoveredRepo
+			switch event.EventName {
+			case "project_create", "project_rename", "project_transfer", "project_update":
+				if inGroup(name) {
+					// System hooks leave out whether the project is archived
+					// or a fork, and its topics, so ask the API to have the
+					// filters of the source see what refreshing would.
+					var err error
+					repo, err = getGitLabRepo(r.Context(), &s.config, name)
+					if err != nil {
+						return false, err
+					}
+				}
+			case "project_destroy":
+				oldName = name
+			default:
+				continue
+			}
+			// Events for repos the source doesn't serve, like private
+			// ones, are ignored.
+			changed, err := h.updateRepo(s, oldName, repo)
+			if err != nil || !changed {
+				return false, err
+			}
+			slog.InfoContext(r.Context(), "repo changed", "source", s.name, "action", event.EventName, "repo", name)
+			return true, nil
+		}
+	}
+	if !verified {
+		return false, errWebhookSignature
+	}
+	return false, nil
+}
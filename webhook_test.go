@@ -0,0 +1,216 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testWebhookSecret = "s3cret"
+
+// setupWebhooks serves a single host whose paths are discovered by dc,
+// which is given the test secret.
+func setupWebhooks(t *testing.T, dc discoverConfig) *vanityHost {
+	t.Helper()
+	dc.WebhookSecret = testWebhookSecret
+	return setupHost(t, &hostConfig{Discover: []discoverConfig{dc}})
+}
+
+// sendWebhook posts body to the webhook at path with header and returns
+// the status of the response.
+func sendWebhook(path string, header http.Header, body string) int {
+	return sendRequest(serveWebhook, "POST", webhookPrefix+path, header, body).Code
+}
+
+// githubSignature returns the X-Hub-Signature-256 header for body.
+func githubSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestGitHubWebhookSignature(t *testing.T) {
+	setupWebhooks(t, discoverConfig{GitHub: "rakyll"})
+	const body = `{"zen": "Keep it logically awesome."}`
+	valid := githubSignature(testWebhookSecret, body)
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{"valid", valid, http.StatusAccepted},
+		{"wrong secret", githubSignature("other", body), http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+		{"malformed hex", "sha256=not-hex", http.StatusUnauthorized},
+		{"truncated", valid[:len(valid)-2], http.StatusUnauthorized},
+		{"no algorithm", strings.TrimPrefix(valid, "sha256="), http.StatusUnauthorized},
+		{"sha1", "sha1=" + strings.TrimPrefix(valid, "sha256="), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		header := http.Header{"X-Github-Event": {"ping"}}
+		if test.signature != "" {
+			header.Set("X-Hub-Signature-256", test.signature)
+		}
+		if code := sendWebhook("github", header, body); code != test.want {
+			t.Errorf("%s: webhook with signature %q = %d; want %d", test.name, test.signature, code, test.want)
+		}
+	}
+}
+
+func TestGitHubWebhookRepository(t *testing.T) {
+	h := setupWebhooks(t, discoverConfig{GitHub: "rakyll"})
+	send := func(action, name string, archived bool) int {
+		t.Helper()
+		data, err := json.Marshal(map[string]interface{}{
+			"action": action,
+			"repository": map[string]interface{}{
+				"name":      name,
+				"full_name": "rakyll/" + name,
+				"html_url":  "https://github.com/rakyll/" + name,
+				"archived":  archived,
+				"owner":     map[string]string{"login": "rakyll"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := string(data)
+		header := http.Header{
+			"X-Github-Event":      {"repository"},
+			"X-Hub-Signature-256": {githubSignature(testWebhookSecret, body)},
+		}
+		return sendWebhook("github", header, body)
+	}
+
+	if code := send("created", "portmidi", false); code != http.StatusNoContent {
+		t.Errorf("created = %d; want %d", code, http.StatusNoContent)
+	}
+	if p, ok := h.pathMap()["/portmidi"]; !ok || p.Repo != "https://github.com/rakyll/portmidi" {
+		t.Errorf("after created, /portmidi = %+v, %t; want https://github.com/rakyll/portmidi", p, ok)
+	}
+	if code := send("created", "old", true); code != http.StatusAccepted {
+		t.Errorf("created for an archived repo = %d; want %d", code, http.StatusAccepted)
+	}
+	if _, ok := h.pathMap()["/old"]; ok {
+		t.Error("archived repo is served")
+	}
+	if code := send("archived", "portmidi", true); code != http.StatusNoContent {
+		t.Errorf("archived = %d; want %d", code, http.StatusNoContent)
+	}
+	if _, ok := h.pathMap()["/portmidi"]; ok {
+		t.Error("/portmidi is still served after it was archived")
+	}
+}
+
+// newGitLabAPI returns a fake GitLab API that serves projects, by their
+// paths with namespaces.
+func newGitLabAPI(t *testing.T, projects map[string]gitlabProject) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := projects[strings.TrimPrefix(r.URL.Path, "/api/v4/projects/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(p)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testGitLabProject returns a project of the GitLab group rakyll as the
+// API describes it.
+func testGitLabProject(t *testing.T, name, extra string) gitlabProject {
+	t.Helper()
+	var p gitlabProject
+	data := `{"path": "` + name + `", "path_with_namespace": "rakyll/` + name + `", "web_url": "https://gitlab.example.com/rakyll/` + name + `", "visibility": "public"` + extra + `}`
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGitLabWebhookToken(t *testing.T) {
+	api := newGitLabAPI(t, nil)
+	setupWebhooks(t, discoverConfig{GitLab: "rakyll", API: api.URL + "/api/v4", Token: "api-token"})
+	// The project is in another group, so nothing changes.
+	const body = `{"event_name": "project_create", "path_with_namespace": "other/portmidi"}`
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"valid", testWebhookSecret, http.StatusAccepted},
+		{"wrong", "other", http.StatusUnauthorized},
+		{"prefix", testWebhookSecret[:3], http.StatusUnauthorized},
+		{"longer", testWebhookSecret + "x", http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		header := make(http.Header)
+		if test.token != "" {
+			header.Set("X-Gitlab-Token", test.token)
+		}
+		if code := sendWebhook("gitlab", header, body); code != test.want {
+			t.Errorf("%s: webhook with token %q = %d; want %d", test.name, test.token, code, test.want)
+		}
+	}
+}
+
+func TestGitLabWebhookFilters(t *testing.T) {
+	api := newGitLabAPI(t, map[string]gitlabProject{
+		"rakyll/portmidi": testGitLabProject(t, "portmidi", ""),
+		"rakyll/old":      testGitLabProject(t, "old", `, "archived": true`),
+		"rakyll/fork":     testGitLabProject(t, "fork", `, "forked_from_project": {"id": 1}`),
+		"rakyll/secret":   testGitLabProject(t, "secret", `, "visibility": "internal"`),
+	})
+	h := setupWebhooks(t, discoverConfig{GitLab: "rakyll", API: api.URL + "/api/v4", Token: "api-token"})
+	tests := []struct {
+		name  string
+		want  int
+		serve bool
+	}{
+		{"portmidi", http.StatusNoContent, true},
+		{"old", http.StatusAccepted, false},
+		{"fork", http.StatusAccepted, false},
+		{"secret", http.StatusAccepted, false},
+		// Deleted before the API was asked about it.
+		{"gone", http.StatusAccepted, false},
+	}
+	for _, test := range tests {
+		body := `{"event_name": "project_create", "path_with_namespace": "rakyll/` + test.name + `"}`
+		header := http.Header{"X-Gitlab-Token": {testWebhookSecret}}
+		if code := sendWebhook("gitlab", header, body); code != test.want {
+			t.Errorf("project_create for %s = %d; want %d", test.name, code, test.want)
+		}
+		if _, ok := h.pathMap()["/"+test.name]; ok != test.serve {
+			t.Errorf("after project_create, /%s served = %t; want %t", test.name, ok, test.serve)
+		}
+	}
+	body := `{"event_name": "project_destroy", "path_with_namespace": "rakyll/portmidi"}`
+	if code := sendWebhook("gitlab", http.Header{"X-Gitlab-Token": {testWebhookSecret}}, body); code != http.StatusNoContent {
+		t.Errorf("project_destroy = %d; want %d", code, http.StatusNoContent)
+	}
+	if _, ok := h.pathMap()["/portmidi"]; ok {
+		t.Error("/portmidi is still served after project_destroy")
+	}
+}